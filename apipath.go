@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/neoul/yangtree"
+)
+
+// RFC8040 3.5.3. Encoding Data Resource Identifiers in the Request URI
+//
+//	api-path = root *("/" (api-identifier / list-instance))
+//	api-identifier = [module-name ":"] identifier
+//	module-name = identifier
+//	list-instance = api-identifier "=" key-value *("," key-value)
+//	key-value = string  ; constrained chars are percent-encoded
+//	identifier = (ALPHA / "_") *(ALPHA / DIGIT / "_" / "-" / ".")
+
+// APIPathSegment is a path segment (api-identifier or list-instance) of the api-path.
+type APIPathSegment struct {
+	Module string   // module-name: empty if not qualified
+	Name   string   // identifier
+	Values []string // percent-decoded key-values: nil if not a list-instance
+	Raw    string   // the segment in the api-path
+}
+
+// APIPathError is the error of the api-path resolution reported with the error-tag
+// and the error-path that is the api-path up to the erroneous segment.
+type APIPathError struct {
+	Tag  ErrorTag
+	Path string
+	Msg  string
+}
+
+func (e *APIPathError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// isIdentifier() returns true if the s is a YANG identifier.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch == '_':
+		case i > 0 && (ch >= '0' && ch <= '9' || ch == '-' || ch == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAPIPath() parses the api-path to the path segments. The root ("/")
+// and the trailing "/" are allowed. The key-values are percent-decoded.
+func ParseAPIPath(apipath string) ([]*APIPathSegment, error) {
+	var segments []*APIPathSegment
+	if apipath == "" || apipath == "/" {
+		return segments, nil
+	}
+	apipath = strings.TrimPrefix(apipath, "/")
+	apipath = strings.TrimSuffix(apipath, "/")
+	for _, raw := range strings.Split(apipath, "/") {
+		seg := &APIPathSegment{Raw: raw}
+		id := raw
+		if i := strings.Index(raw, "="); i >= 0 {
+			id = raw[:i]
+			for _, v := range strings.Split(raw[i+1:], ",") {
+				value, err := url.PathUnescape(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid key-value %q in %q", v, raw)
+				}
+				seg.Values = append(seg.Values, value)
+			}
+		}
+		if i := strings.Index(id, ":"); i >= 0 {
+			seg.Module = id[:i]
+			id = id[i+1:]
+			if !isIdentifier(seg.Module) {
+				return nil, fmt.Errorf("invalid module-name %q in %q", seg.Module, raw)
+			}
+		}
+		if !isIdentifier(id) {
+			return nil, fmt.Errorf("invalid identifier %q in %q", id, raw)
+		}
+		seg.Name = id
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// String() returns the path segment encoded in the api-path.
+func (seg *APIPathSegment) String() string {
+	var b strings.Builder
+	if seg.Module != "" {
+		b.WriteString(seg.Module)
+		b.WriteString(":")
+	}
+	b.WriteString(seg.Name)
+	for i := range seg.Values {
+		if i == 0 {
+			b.WriteString("=")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(escapeValue(seg.Values[i]))
+	}
+	return b.String()
+}
+
+// FormatAPIPath() returns the api-path of the path segments.
+func FormatAPIPath(segments []*APIPathSegment) string {
+	var b strings.Builder
+	for i := range segments {
+		b.WriteString("/")
+		b.WriteString(segments[i].String())
+	}
+	return b.String()
+}
+
+// xpathValue() returns the key value used in the XPath predicate.
+// The value is quoted if it has the characters reserved in the XPath.
+// The value having both single and double quotes is not able to be quoted
+// because XPath 1.0 literals have no escape.
+func xpathValue(value string) (string, error) {
+	if value != "" && !strings.ContainsAny(value, "[]/='\" ") {
+		return value, nil
+	}
+	if strings.Contains(value, "'") {
+		if strings.Contains(value, `"`) {
+			return "", fmt.Errorf("unable to quote %s having both ' and \"", value)
+		}
+		return `"` + value + `"`, nil
+	}
+	return "'" + value + "'", nil
+}
+
+// escapeValue() percent-encodes the key-value of the list-instance.
+// The comma (",") is also encoded to be distinguished from the key separator.
+func escapeValue(value string) string {
+	return strings.ReplaceAll(url.PathEscape(value), ",", "%2C")
+}
+
+// moduleName() returns the name of the module that defines the schema node.
+// The module of the node augmented by other module is the augmenting module.
+func moduleName(schema *yangtree.SchemaNode) string {
+	if schema == nil || schema.Module == nil {
+		return ""
+	}
+	return schema.Module.Name
+}
+
+// apiIdentifier() returns the api-identifier of the schema node. The name is
+// qualified by the module-name if the node is a top-level data node or the
+// module of the node is different from the module of the parent node.
+func apiIdentifier(schema *yangtree.SchemaNode) string {
+	parent := dataParent(schema)
+	if parent == nil || moduleName(parent) == "" || moduleName(parent) != moduleName(schema) {
+		if mname := moduleName(schema); mname != "" {
+			return mname + ":" + schema.Name
+		}
+	}
+	return schema.Name
+}
+
+// childSchemas() returns the child data schema nodes of the name. The child
+// nodes of the choice and case nodes are the children of the schema.
+func childSchemas(schema *yangtree.SchemaNode, name string, found []*yangtree.SchemaNode) []*yangtree.SchemaNode {
+	for _, cschema := range schema.Children {
+		if cschema.IsChoice() || cschema.IsCase() {
+			found = childSchemas(cschema, name, found)
+		} else if cschema.Name == name {
+			found = append(found, cschema)
+		}
+	}
+	return found
+}
+
+// resolveSegment() returns the child schema node of the schema identified by
+// the path segment. The module-name of the segment is used to distinguish the
+// nodes of the same name defined by different modules. The unqualified name
+// is resolved to the node of the module of the schema if ambiguous.
+func resolveSegment(schema *yangtree.SchemaNode, seg *APIPathSegment) (*yangtree.SchemaNode, ErrorTag, string) {
+	candidates := childSchemas(schema, seg.Name, nil)
+	if len(candidates) == 0 {
+		return nil, ETagUnknownElement, fmt.Sprintf("unable to find schema %s", seg.Name)
+	}
+	if seg.Module != "" {
+		for _, cschema := range candidates {
+			if moduleName(cschema) == seg.Module {
+				return cschema, 0, ""
+			}
+		}
+		return nil, ETagUnknownNamespace,
+			fmt.Sprintf("%s is not defined in module %s", seg.Name, seg.Module)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], 0, ""
+	}
+	for _, cschema := range candidates {
+		if moduleName(cschema) == moduleName(schema) {
+			return cschema, 0, ""
+		}
+	}
+	return nil, ETagInvalidValue,
+		fmt.Sprintf("%s is defined in multiple modules; module-name must be specified", seg.Name)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ParseAPIPath(t *testing.T) {
+	tests := []struct {
+		apipath string
+		want    []APIPathSegment
+		wantErr bool
+	}{
+		{apipath: "/", want: nil},
+		{apipath: "/example-jukebox:jukebox/library/artist=Foo%20Fighters/album=Wasting%20Light/",
+			want: []APIPathSegment{
+				{Module: "example-jukebox", Name: "jukebox"},
+				{Name: "library"},
+				{Name: "artist", Values: []string{"Foo Fighters"}},
+				{Name: "album", Values: []string{"Wasting Light"}},
+			}},
+		{apipath: "/example-top:top/list1=%2C%27\"%3A\"%20%2F,,foo",
+			want: []APIPathSegment{
+				{Module: "example-top", Name: "top"},
+				{Name: "list1", Values: []string{`,'":" /`, "", "foo"}},
+			}},
+		{apipath: "/top/list1=foo,,baz",
+			want: []APIPathSegment{
+				{Name: "top"},
+				{Name: "list1", Values: []string{"foo", "", "baz"}},
+			}},
+		{apipath: "/top/leaflist=", want: []APIPathSegment{
+			{Name: "top"},
+			{Name: "leaflist", Values: []string{""}},
+		}},
+		{apipath: "/top//list1", wantErr: true},
+		{apipath: "/1top", wantErr: true},
+		{apipath: "/:top", wantErr: true},
+		{apipath: "/mod:", wantErr: true},
+		{apipath: "/top/list1=%G0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apipath, func(t *testing.T) {
+			got, err := ParseAPIPath(tt.apipath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAPIPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if segments := stripRaw(got); !reflect.DeepEqual(segments, tt.want) {
+				t.Errorf("ParseAPIPath() = %v, want %v", segments, tt.want)
+			}
+		})
+	}
+}
+
+// stripRaw() returns the path segments without the raw segments to compare.
+func stripRaw(segments []*APIPathSegment) []APIPathSegment {
+	var result []APIPathSegment
+	for i := range segments {
+		seg := *segments[i]
+		seg.Raw = ""
+		result = append(result, seg)
+	}
+	return result
+}
+
+func Fuzz_ParseAPIPath(f *testing.F) {
+	f.Add("/example-jukebox:jukebox/library/artist=Foo%20Fighters/album=Wasting%20Light")
+	f.Add("/example-top:top/list1=%2C%27\"%3A\"%20%2F,,foo")
+	f.Add("/top/leaflist=")
+	f.Fuzz(func(t *testing.T, apipath string) {
+		segments, err := ParseAPIPath(apipath)
+		if err != nil {
+			return
+		}
+		formatted := FormatAPIPath(segments)
+		again, err := ParseAPIPath(formatted)
+		if err != nil {
+			t.Fatalf("ParseAPIPath(%q) of %q: %v", formatted, apipath, err)
+		}
+		if !reflect.DeepEqual(stripRaw(again), stripRaw(segments)) {
+			t.Errorf("ParseAPIPath(%q) = %v, want %v", formatted, stripRaw(again), stripRaw(segments))
+		}
+	})
+}
+
+func Fuzz_FormatAPIPath(f *testing.F) {
+	f.Add("example-top", "list1", `,'":" /`, "")
+	f.Add("", "leaflist", "fred", "%2C")
+	f.Fuzz(func(t *testing.T, module, name, key1, key2 string) {
+		if !isIdentifier(name) || module != "" && !isIdentifier(module) {
+			return
+		}
+		segments := []*APIPathSegment{
+			{Module: module, Name: name},
+			{Name: name, Values: []string{key1}},
+			{Name: name, Values: []string{key1, key2}},
+		}
+		got, err := ParseAPIPath(FormatAPIPath(segments))
+		if err != nil {
+			t.Fatalf("ParseAPIPath(%q): %v", FormatAPIPath(segments), err)
+		}
+		if !reflect.DeepEqual(stripRaw(got), stripRaw(segments)) {
+			t.Errorf("ParseAPIPath(%q) = %v, want %v", FormatAPIPath(segments), stripRaw(got), stripRaw(segments))
+		}
+	})
+}
+
+func Test_xpathValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "foo", want: "foo"},
+		{value: "", want: "''"},
+		{value: "a/b", want: "'a/b'"},
+		{value: "Guns N' Roses", want: `"Guns N' Roses"`},
+		{value: `say "hi"`, want: `'say "hi"'`},
+		{value: `it's "hi"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := xpathValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("xpathValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("xpathValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
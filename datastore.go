@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/neoul/yangtree"
+)
+
+// Datastore is the transactional accessor of the datastore for the rpc and
+// action handlers that run without the datastore lock. The data nodes of the
+// datastore must not be used out of the Read() and Edit() functions.
+type Datastore struct {
+	rc *RESTCtrl
+}
+
+// Read() calls the function with the datastore root under the read lock.
+func (ds *Datastore) Read(f func(root yangtree.DataNode) error) error {
+	ds.rc.RLock()
+	defer ds.rc.RUnlock()
+	return f(ds.rc.DataRoot)
+}
+
+// Edit() calls the function with the datastore root under the write lock to
+// edit the data nodes of the xpath ("" for the whole datastore). The function
+// must not change the data nodes out of the xpath. Only the data nodes of the
+// xpath are saved before the edit, and all changes of them are discarded if
+// the function returns an error; otherwise the revision of the xpath is
+// updated if any of them is changed.
+func (ds *Datastore) Edit(xpath string, f func(root yangtree.DataNode) error) error {
+	ds.rc.Lock()
+	defer ds.rc.Unlock()
+	snap, err := newSnapshot(ds.rc.DataRoot, xpath)
+	if err != nil {
+		return err
+	}
+	if err := f(ds.rc.DataRoot); err != nil {
+		if rerr := snap.restore(); rerr != nil {
+			return fmt.Errorf("%v: unable to discard the changes: %v", err, rerr)
+		}
+		return err
+	}
+	if snap.changed() {
+		ds.rc.revisions.Touch(xpath)
+	}
+	return nil
+}
+
+// Find() returns the copies of the data nodes of the xpath in the datastore.
+func (ds *Datastore) Find(xpath string) ([]yangtree.DataNode, error) {
+	var nodes []yangtree.DataNode
+	err := ds.Read(func(root yangtree.DataNode) error {
+		found, err := yangtree.Find(root, xpath)
+		for i := range found {
+			nodes = append(nodes, yangtree.Clone(found[i]))
+		}
+		return err
+	})
+	return nodes, err
+}
+
+// snapshot is the copy of the data nodes of an xpath taken before an edit.
+// The parents are the parents of the data nodes, or the nearest existing
+// ancestors if the data nodes do not exist yet, with the ids of their
+// children to remove the children created by the edit.
+type snapshot struct {
+	parents []yangtree.DataNode
+	ids     []map[string]bool
+	entries []snapshotEntry
+}
+
+// snapshotEntry is the copy of a data node and its position in the parent.
+type snapshotEntry struct {
+	parent yangtree.DataNode
+	id     string
+	backup yangtree.DataNode
+	pos    *yangtree.EditOption
+}
+
+// newSnapshot() takes the snapshot of the data nodes of the xpath.
+func newSnapshot(root yangtree.DataNode, xpath string) (*snapshot, error) {
+	s := &snapshot{}
+	elems := splitXPath(xpath)
+	if len(elems) == 0 {
+		// The whole datastore is saved by the children of the root.
+		s.addParent(root)
+		for _, child := range root.Children() {
+			s.entries = append(s.entries, snapshotEntry{parent: root, id: child.ID(),
+				backup: yangtree.Clone(child)})
+		}
+		return s, nil
+	}
+	for i := len(elems); i > 0; i-- {
+		found, err := yangtree.Find(root, strings.Join(elems[:i], "/"))
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			continue
+		}
+		for _, node := range found {
+			if i < len(elems) {
+				s.addParent(node)
+				continue
+			}
+			s.addParent(node.Parent())
+			s.entries = append(s.entries, snapshotEntry{parent: node.Parent(), id: node.ID(),
+				backup: yangtree.Clone(node), pos: positionOf(node)})
+		}
+		return s, nil
+	}
+	s.addParent(root)
+	return s, nil
+}
+
+// addParent() saves the ids of the children of the parent.
+func (s *snapshot) addParent(parent yangtree.DataNode) {
+	for i := range s.parents {
+		if s.parents[i] == parent {
+			return
+		}
+	}
+	ids := map[string]bool{}
+	for _, child := range parent.Children() {
+		ids[child.ID()] = true
+	}
+	s.parents = append(s.parents, parent)
+	s.ids = append(s.ids, ids)
+}
+
+// restore() restores the data nodes of the snapshot and removes the data
+// nodes created after the snapshot.
+func (s *snapshot) restore() error {
+	for i, parent := range s.parents {
+		var created []yangtree.DataNode
+		for _, child := range parent.Children() {
+			if !s.ids[i][child.ID()] {
+				created = append(created, child)
+			}
+		}
+		for _, child := range created {
+			if err := parent.Delete(child); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range s.entries {
+		if err := restoreChild(e.parent, e.id, e.backup, e.pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changed() returns true if any data node of the snapshot is changed,
+// removed or created after the snapshot.
+func (s *snapshot) changed() bool {
+	for i, parent := range s.parents {
+		children := parent.Children()
+		for _, child := range children {
+			if !s.ids[i][child.ID()] {
+				return true
+			}
+		}
+		if len(children) != len(s.ids[i]) {
+			return true
+		}
+	}
+	for _, e := range s.entries {
+		node := e.parent.Get(e.id)
+		if node == nil {
+			return true
+		}
+		b1, err1 := yangtree.MarshalJSON(e.backup)
+		b2, err2 := yangtree.MarshalJSON(node)
+		if err1 != nil || err2 != nil || !bytes.Equal(b1, b2) {
+			return true
+		}
+	}
+	return false
+}
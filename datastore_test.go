@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_DatastoreEdit(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	ds := &Datastore{rc: rc}
+	tests := []struct {
+		name    string
+		id      string
+		status  string
+		fail    bool
+		want    string // the status after the edit: empty if not exist
+		counter uint64
+	}{
+		{name: "created", id: "1", status: "running", want: "running", counter: 1},
+		{name: "discarded creation", id: "2", status: "running", fail: true, counter: 1},
+		{name: "discarded change", id: "1", status: "failed", fail: true, want: "running", counter: 1},
+		{name: "unchanged", id: "1", status: "running", want: "running", counter: 1},
+		{name: "changed", id: "1", status: "completed", want: "completed", counter: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xpath := fmt.Sprintf("jobs/job[id=%s]", tt.id)
+			err := ds.Edit(xpath, func(root yangtree.DataNode) error {
+				if err := yangtree.SetValue(root, xpath+"/status", nil, tt.status); err != nil {
+					return err
+				}
+				if tt.fail {
+					return fmt.Errorf("failure")
+				}
+				return nil
+			})
+			if (err != nil) != tt.fail {
+				t.Fatalf("Edit() error = %v, fail %v", err, tt.fail)
+			}
+			if rc.DataRoot != root {
+				t.Fatalf("Edit() replaced the datastore root")
+			}
+			found, err := yangtree.Find(root, xpath+"/status")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if len(found) == 1 {
+				got = found[0].ValueString()
+			}
+			if got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if got := rc.revisions.Get("").Counter; got != tt.counter {
+				t.Errorf("revision = %d, want %d", got, tt.counter)
+			}
+		})
+	}
+}
+
+func Test_DatastoreUnlockedRPC(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-ops.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yangtree.SetValue(root, "restconf-state/capabilities/capability", nil, capabilities[0]); err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	started, release := make(chan struct{}), make(chan struct{})
+	err = rc.RegisterRPC("/example-ops:get-reboot-info", func(req *RPCRequest) (yangtree.DataNode, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteRESTCONF(app, rc); err != nil {
+		t.Fatal(err)
+	}
+
+	invoked := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("POST", "/restconf/operations/example-ops:get-reboot-info", nil)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Error(err)
+			invoked <- 0
+			return
+		}
+		invoked <- resp.StatusCode
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rpc not invoked")
+	}
+
+	// The datastore must be readable while the rpc is in flight.
+	read := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "/restconf/data/ietf-restconf-monitoring:restconf-state", nil)
+		req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Error(err)
+			read <- 0
+			return
+		}
+		read <- resp.StatusCode
+	}()
+	select {
+	case code := <-read:
+		if code != fiber.StatusOK {
+			t.Errorf("GET status = %d while the rpc in flight, want %d", code, fiber.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("GET blocked by the rpc in flight")
+	}
+	close(release)
+	if code := <-invoked; code != fiber.StatusNoContent {
+		t.Errorf("POST status = %d, want %d", code, fiber.StatusNoContent)
+	}
+}
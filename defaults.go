@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/neoul/yangtree"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// RFC6243 With-defaults Capability for NETCONF
+//
+// The server stores only the data nodes explicitly set by clients, so the
+// basic-mode of the server is "explicit".
+
+const (
+	wdNamespace   = "urn:ietf:params:xml:ns:netconf:default:1.0"
+	wdJSONDefault = `{"ietf-netconf-with-defaults:default":true}`
+)
+
+// schemaDefault() returns the default value of the leaf schema.
+func schemaDefault(schema *yangtree.SchemaNode) (string, bool) {
+	if !schema.IsLeaf() || len(schema.Default) == 0 {
+		return "", false
+	}
+	return schema.Default[0], true
+}
+
+// isDefaultNode() returns true if the node is a leaf that has the default value.
+func isDefaultNode(node yangtree.DataNode) bool {
+	if !node.IsLeaf() {
+		return false
+	}
+	def, ok := schemaDefault(node.Schema())
+	return ok && node.ValueString() == def
+}
+
+// addDefaults() adds the default nodes not present in the node and
+// its descendants for the "report-all" mode. (RFC6243 2.1)
+func addDefaults(node yangtree.DataNode) {
+	if !node.IsBranchNode() {
+		return
+	}
+	for _, child := range copyNodes(node.Children()) {
+		addDefaults(child)
+	}
+	addSchemaDefaults(node, node.Schema())
+}
+
+// addSchemaDefaults() adds the default nodes of the child schema nodes of
+// the schema to the node. The default nodes of the case selected by the
+// data nodes of the case or the default case of the choice are added.
+func addSchemaDefaults(node yangtree.DataNode, schema *yangtree.SchemaNode) {
+	for _, cschema := range schema.Children {
+		if !cschema.IsChoice() {
+			addChildDefaults(node, cschema)
+			continue
+		}
+		var selected *yangtree.SchemaNode
+		for _, c := range cschema.Children {
+			if hasCaseData(node, c) {
+				selected = c
+				break
+			}
+		}
+		if selected == nil {
+			selected = defaultCase(cschema)
+		}
+		switch {
+		case selected == nil:
+		case selected.IsCase():
+			addSchemaDefaults(node, selected)
+		default: // the shorthand case
+			addChildDefaults(node, selected)
+		}
+	}
+}
+
+// addChildDefaults() adds the default leaf of the schema or the
+// non-presence container of the schema that has default nodes to the
+// node if it is not present in the node.
+func addChildDefaults(node yangtree.DataNode, cschema *yangtree.SchemaNode) {
+	if node.Exist(cschema.Name) {
+		return
+	}
+	if def, ok := schemaDefault(cschema); ok {
+		if leaf, err := yangtree.NewWithValue(cschema, def); err == nil {
+			node.Insert(leaf, nil)
+		}
+		return
+	}
+	if cschema.IsContainer() && !isPresence(cschema) {
+		child, err := yangtree.New(cschema)
+		if err != nil {
+			return
+		}
+		addSchemaDefaults(child, cschema)
+		if child.Len() > 0 {
+			node.Insert(child, nil)
+		}
+	}
+}
+
+// defaultCase() returns the default case of the choice schema or nil.
+func defaultCase(choice *yangtree.SchemaNode) *yangtree.SchemaNode {
+	n, ok := choice.Node.(*yang.Choice)
+	if !ok || n.Default == nil {
+		return nil
+	}
+	for _, c := range choice.Children {
+		if c.Name == n.Default.Name {
+			return c
+		}
+	}
+	return nil
+}
+
+// trimDefaults() deletes the leafs that have the default value from the
+// node and its descendants for the "trim" mode.
+func trimDefaults(node yangtree.DataNode) {
+	for _, child := range copyNodes(node.Children()) {
+		if child.IsBranchNode() {
+			trimDefaults(child)
+		} else if isDefaultNode(child) {
+			node.Delete(child)
+		}
+	}
+}
+
+// defaultPaths() returns the document paths of the leafs and leaf-list
+// entries that have the default value in the nodes. The path consists of the
+// node names with the index among the siblings of the same name, such as
+// "interface[0]/mtu[0]", so that the default nodes are matched to the
+// elements or members of the encoded document regardless of the order of the
+// different names in the document.
+func defaultPaths(nodes []yangtree.DataNode) map[string]bool {
+	paths := map[string]bool{}
+	var walk func(prefix string, nodes []yangtree.DataNode)
+	walk = func(prefix string, nodes []yangtree.DataNode) {
+		count := map[string]int{}
+		for _, node := range nodes {
+			name := node.Name()
+			path := fmt.Sprintf("%s%s[%d]", prefix, name, count[name])
+			count[name]++
+			if node.IsBranchNode() {
+				walk(path+"/", node.Children())
+			} else if isDefaultNode(node) {
+				paths[path] = true
+			}
+		}
+	}
+	walk("", nodes)
+	return paths
+}
+
+// isDefaultPath() returns true if the document path or any of its suffixes
+// starting at an element is one of the default paths. The suffix is matched
+// because the document may have the wrapper elements out of the nodes.
+func isDefaultPath(paths map[string]bool, path string) bool {
+	for p := strings.TrimPrefix(path, "/"); ; {
+		if paths[p] {
+			return true
+		}
+		i := strings.IndexByte(p, '/')
+		if i < 0 {
+			return false
+		}
+		p = p[i+1:]
+	}
+}
+
+// memberName() returns the member name of the JSON object without the
+// module-name.
+func memberName(key string) string {
+	if i := strings.LastIndexByte(key, ':'); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
+
+type insertion struct {
+	pos  int
+	text string
+}
+
+// insert() inserts the texts to the positions of the b.
+func insert(b []byte, ins []insertion) []byte {
+	sort.SliceStable(ins, func(i, j int) bool { return ins[i].pos < ins[j].pos })
+	var out bytes.Buffer
+	last := 0
+	for i := range ins {
+		out.Write(b[last:ins[i].pos])
+		out.WriteString(ins[i].text)
+		last = ins[i].pos
+	}
+	out.Write(b[last:])
+	return out.Bytes()
+}
+
+// tagDefaultsXML() adds the wd:default="true" attribute (RFC6243 6.) to the
+// childless elements of the XML document of the default paths.
+func tagDefaultsXML(b []byte, paths map[string]bool) ([]byte, error) {
+	type element struct {
+		path     string
+		pos      int // the position to insert the attribute
+		hasChild bool
+		count    map[string]int // the number of the child elements by name
+	}
+	stack := []*element{{count: map[string]int{}}}
+	var ins []insertion
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			parent.hasChild = true
+			name := t.Name.Local
+			path := fmt.Sprintf("%s/%s[%d]", parent.path, name, parent.count[name])
+			parent.count[name]++
+			end := int(d.InputOffset())
+			pos := end - 1 // '>'
+			if end >= 2 && b[end-2] == '/' {
+				pos = end - 2 // '/>'
+			}
+			stack = append(stack, &element{path: path, pos: pos, count: map[string]int{}})
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected end element")
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !e.hasChild && isDefaultPath(paths, e.path) {
+				ins = append(ins, insertion{pos: e.pos,
+					text: ` xmlns:wd="` + wdNamespace + `" wd:default="true"`})
+			}
+		}
+	}
+	return insert(b, ins), nil
+}
+
+// tagDefaultsJSON() adds the "ietf-netconf-with-defaults:default" metadata
+// (RFC7952 5.) to the leafs and leaf-lists of the JSON document of the
+// default paths.
+func tagDefaultsJSON(b []byte, paths map[string]bool) ([]byte, error) {
+	type context struct {
+		object  bool
+		path    string // the path of the object or the array without index
+		key     string // the member name of the value in the object
+		iskey   bool   // true if the next string token is a member name
+		index   int    // the index of the next value in the array
+		values  []bool // tags of the leaf-list entries in the array
+		scalar  bool   // true if the array has scalar values
+		null    bool   // true if the array has null (empty leaf)
+		nullTag bool   // true if the empty leaf has the default tag
+	}
+	var stack []*context
+	var ins []insertion
+	// valuePath() returns the path of the next value in the top context.
+	valuePath := func(top *context) string {
+		if top == nil {
+			return ""
+		}
+		if top.object {
+			return fmt.Sprintf("%s/%s[0]", top.path, memberName(top.key))
+		}
+		top.index++
+		return fmt.Sprintf("%s[%d]", top.path, top.index-1)
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var top *context
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.iskey {
+			if key, ok := t.(string); ok {
+				top.key = key
+				top.iskey = false
+				continue
+			}
+		}
+		switch t {
+		case json.Delim('{'):
+			stack = append(stack, &context{object: true, iskey: true, path: valuePath(top)})
+			continue
+		case json.Delim('['):
+			var path string
+			if top != nil && top.object {
+				path = top.path + "/" + memberName(top.key)
+			}
+			stack = append(stack, &context{path: path})
+			continue
+		case json.Delim('}'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && stack[len(stack)-1].object {
+				stack[len(stack)-1].iskey = true
+			}
+			continue
+		case json.Delim(']'):
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && stack[len(stack)-1].object {
+				parent := stack[len(stack)-1]
+				parent.iskey = true
+				pos := int(d.InputOffset())
+				switch {
+				case e.null && len(e.values) == 0:
+					// [null] of the empty type leaf
+					if e.nullTag {
+						ins = append(ins, insertion{pos: pos,
+							text: fmt.Sprintf(",%q:%s", "@"+parent.key, wdJSONDefault)})
+					}
+				case e.scalar:
+					var metadata []string
+					var tagged bool
+					for _, v := range e.values {
+						if v {
+							tagged = true
+							metadata = append(metadata, wdJSONDefault)
+						} else {
+							metadata = append(metadata, "null")
+						}
+					}
+					if tagged {
+						ins = append(ins, insertion{pos: pos,
+							text: fmt.Sprintf(",%q:[%s]", "@"+parent.key, strings.Join(metadata, ","))})
+					}
+				}
+			}
+			continue
+		}
+		// scalar values
+		if top == nil {
+			continue
+		}
+		tagged := isDefaultPath(paths, valuePath(top))
+		if top.object {
+			top.iskey = true
+			if tagged {
+				ins = append(ins, insertion{pos: int(d.InputOffset()),
+					text: fmt.Sprintf(",%q:%s", "@"+top.key, wdJSONDefault)})
+			}
+			continue
+		}
+		if t == nil {
+			top.null, top.nullTag = true, tagged
+			continue
+		}
+		top.scalar = true
+		top.values = append(top.values, tagged)
+	}
+	return insert(b, ins), nil
+}
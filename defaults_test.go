@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_isDefaultPath(t *testing.T) {
+	paths := map[string]bool{"interface[0]/mtu[0]": true}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/interface[0]/mtu[0]", want: true},
+		{path: "/interfaces[0]/interface[0]/mtu[0]", want: true},
+		{path: "/interface[1]/mtu[0]", want: false},
+		{path: "/interface[0]/xmtu[0]", want: false},
+		{path: "/mtu[0]", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isDefaultPath(paths, tt.path); got != tt.want {
+				t.Errorf("isDefaultPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tagDefaultsXML(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		paths   map[string]bool
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "leafs",
+			doc:   `<interface xmlns="urn:x"><name>eth0</name><mtu>1500</mtu><status>up</status></interface>`,
+			paths: map[string]bool{"interface[0]/mtu[0]": true},
+			want: `<interface xmlns="urn:x"><name>eth0</name><mtu xmlns:wd="` + wdNamespace +
+				`" wd:default="true">1500</mtu><status>up</status></interface>`,
+		},
+		{
+			name:  "elements out of the node order",
+			doc:   `<interface><status>up</status><mtu>1500</mtu><name>eth0</name></interface>`,
+			paths: map[string]bool{"interface[0]/mtu[0]": true},
+			want: `<interface><status>up</status><mtu xmlns:wd="` + wdNamespace +
+				`" wd:default="true">1500</mtu><name>eth0</name></interface>`,
+		},
+		{
+			name:  "empty branch and empty leaf",
+			doc:   "<top>\n <empty/>\n <flag/>\n</top>",
+			paths: map[string]bool{"top[0]/flag[0]": true},
+			want:  "<top>\n <empty/>\n <flag xmlns:wd=\"" + wdNamespace + "\" wd:default=\"true\"/>\n</top>",
+		},
+		{
+			name:  "same names and values",
+			doc:   `<top><a><mtu>1500</mtu></a><b><mtu>1500</mtu></b></top>`,
+			paths: map[string]bool{"top[0]/b[0]/mtu[0]": true},
+			want: `<top><a><mtu>1500</mtu></a><b><mtu xmlns:wd="` + wdNamespace +
+				`" wd:default="true">1500</mtu></b></top>`,
+		},
+		{
+			name:  "list entries",
+			doc:   `<song><name>a</name><rate>1</rate></song><song><name>b</name><rate>1</rate></song>`,
+			paths: map[string]bool{"song[1]/rate[0]": true},
+			want: `<song><name>a</name><rate>1</rate></song><song><name>b</name><rate xmlns:wd="` +
+				wdNamespace + `" wd:default="true">1</rate></song>`,
+		},
+		{
+			name:  "no default paths",
+			doc:   `<top><a>1</a><b>2</b></top>`,
+			paths: map[string]bool{"top[0]/c[0]": true},
+			want:  `<top><a>1</a><b>2</b></top>`,
+		},
+		{
+			name:    "unexpected end element",
+			doc:     `<top></top></a>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tagDefaultsXML([]byte(tt.doc), tt.paths)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tagDefaultsXML() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("tagDefaultsXML() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tagDefaultsJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		paths   map[string]bool
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "leafs",
+			doc:   `{"ex:interface":{"name":"eth0","mtu":1500,"enabled":true}}`,
+			paths: map[string]bool{"interface[0]/mtu[0]": true},
+			want:  `{"ex:interface":{"name":"eth0","mtu":1500,"@mtu":` + wdJSONDefault + `,"enabled":true}}`,
+		},
+		{
+			name:  "members out of the node order",
+			doc:   `{"ex:interface":{"enabled":true,"mtu":1500,"name":"eth0"}}`,
+			paths: map[string]bool{"interface[0]/mtu[0]": true},
+			want:  `{"ex:interface":{"enabled":true,"mtu":1500,"@mtu":` + wdJSONDefault + `,"name":"eth0"}}`,
+		},
+		{
+			name:  "leaf-list and empty leaf",
+			doc:   `{"ex:top":{"dns":["a","b"],"flag":[null],"empty":{}}}`,
+			paths: map[string]bool{"top[0]/dns[1]": true, "top[0]/flag[0]": true},
+			want: `{"ex:top":{"dns":["a","b"],"@dns":[null,` + wdJSONDefault + `],"flag":[null],"@flag":` +
+				wdJSONDefault + `,"empty":{}}}`,
+		},
+		{
+			name:  "list entries",
+			doc:   `{"ex:song":[{"name":"a","rate":1},{"name":"b","rate":2}]}`,
+			paths: map[string]bool{"song[0]/rate[0]": true},
+			want:  `{"ex:song":[{"name":"a","rate":1,"@rate":` + wdJSONDefault + `},{"name":"b","rate":2}]}`,
+		},
+		{
+			name:  "same names and values",
+			doc:   `{"ex:top":{"a":{"mtu":1500},"b":{"mtu":1500}}}`,
+			paths: map[string]bool{"top[0]/b[0]/mtu[0]": true},
+			want:  `{"ex:top":{"a":{"mtu":1500},"b":{"mtu":1500,"@mtu":` + wdJSONDefault + `}}}`,
+		},
+		{
+			name:  "no default paths",
+			doc:   `{"ex:top":{"a":1,"b":2}}`,
+			paths: map[string]bool{"top[0]/c[0]": true},
+			want:  `{"ex:top":{"a":1,"b":2}}`,
+		},
+		{
+			name:    "invalid document",
+			doc:     `{"ex:top":{"a":1,}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tagDefaultsJSON([]byte(tt.doc), tt.paths)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tagDefaultsJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("tagDefaultsJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// splitXPath() splits the xpath into the path elements.
+// The '/' in the key predicates ([key=value]) is not regarded as the separator.
+func splitXPath(xpath string) []string {
+	var elems []string
+	var depth int
+	var quote byte
+	begin := 0
+	for i := 0; i < len(xpath); i++ {
+		if quote != 0 {
+			if xpath[i] == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch xpath[i] {
+		case '\'', '"':
+			if depth > 0 {
+				quote = xpath[i]
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				if i > begin {
+					elems = append(elems, xpath[begin:i])
+				}
+				begin = i + 1
+			}
+		}
+	}
+	if begin < len(xpath) {
+		elems = append(elems, xpath[begin:])
+	}
+	return elems
+}
+
+// parentPath() returns the xpath of the parent of the xpath.
+func parentPath(xpath string) string {
+	elems := splitXPath(xpath)
+	if len(elems) == 0 {
+		return ""
+	}
+	return strings.Join(elems[:len(elems)-1], "/")
+}
+
+// copyNodes() returns a copy of the node list to be safe from the
+// modification of the original list while iterating it.
+func copyNodes(nodes []yangtree.DataNode) []yangtree.DataNode {
+	return append([]yangtree.DataNode{}, nodes...)
+}
+
+// childPath() returns the data path of the child node of the xpath. The
+// key values are quoted by xpathValue() as RPath2XPath() does. The xpath is
+// returned if any key value is not able to be quoted.
+func childPath(xpath string, child yangtree.DataNode) string {
+	var b strings.Builder
+	if xpath != "" {
+		b.WriteString(xpath + "/")
+	}
+	schema := child.Schema()
+	seg := nodeSegment(child)
+	b.WriteString(seg.Name)
+	switch {
+	case child.IsLeafList():
+		value, err := xpathValue(seg.Values[0])
+		if err != nil {
+			return xpath
+		}
+		b.WriteString("[.=" + value + "]")
+	case len(seg.Values) > 0:
+		for i := range schema.Keyname {
+			value, err := xpathValue(seg.Values[i])
+			if err != nil {
+				return xpath
+			}
+			b.WriteString("[" + schema.Keyname[i] + "=" + value + "]")
+		}
+	}
+	return b.String()
+}
+
+// dataParent() returns the parent schema of the schema node
+// skipping choice and case schema nodes that are not present in the data tree.
+func dataParent(schema *yangtree.SchemaNode) *yangtree.SchemaNode {
+	p := schema.Parent
+	for p != nil && (p.IsChoice() || p.IsCase()) {
+		p = p.Parent
+	}
+	return p
+}
+
+// getOrNewParent() returns the parent data node of the xpath in the root.
+// The ancestor nodes are created if they don't exist, and the top-most
+// ancestor created is returned to be removed if the edit fails.
+func getOrNewParent(root yangtree.DataNode, xpath string) (yangtree.DataNode, yangtree.DataNode, error) {
+	elems := splitXPath(xpath)
+	if len(elems) <= 1 {
+		return root, nil, nil
+	}
+	ppath := strings.Join(elems[:len(elems)-1], "/")
+	found, err := yangtree.Find(root, ppath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var created yangtree.DataNode
+	if len(found) == 0 {
+		apath := ppath
+		for i := 1; i < len(elems)-1; i++ {
+			if f, err := yangtree.Find(root, strings.Join(elems[:i], "/")); err == nil && len(f) == 0 {
+				apath = strings.Join(elems[:i], "/")
+				break
+			}
+		}
+		if err := yangtree.SetValue(root, ppath, nil); err != nil {
+			return nil, nil, err
+		}
+		if f, err := yangtree.Find(root, apath); err == nil && len(f) == 1 {
+			created = f[0]
+		}
+		if found, err = yangtree.Find(root, ppath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(found) != 1 {
+		return nil, nil, fmt.Errorf("unable to identify the parent node %s", ppath)
+	}
+	return found[0], created, nil
+}
+
+// positionOf() returns the edit option to insert a node to the position of
+// the node in the ordered-by user list. It returns nil if not ordered-by user.
+func positionOf(node yangtree.DataNode) *yangtree.EditOption {
+	if !isOrderedByUser(node.Schema()) || node.Parent() == nil {
+		return nil
+	}
+	siblings := node.Parent().Children()
+	for i := range siblings {
+		if siblings[i] != node {
+			continue
+		}
+		if i+1 < len(siblings) && siblings[i+1].Schema() == node.Schema() {
+			return &yangtree.EditOption{InsertOption: yangtree.InsertToBefore{Key: siblings[i+1].ID()}}
+		}
+		if i > 0 && siblings[i-1].Schema() == node.Schema() {
+			return &yangtree.EditOption{InsertOption: yangtree.InsertToAfter{Key: siblings[i-1].ID()}}
+		}
+		break
+	}
+	return nil
+}
+
+// decodeTarget() decodes the data that represents the target data resource
+// using the decode function and returns the detached target node.
+func (rc *RESTCtrl) decodeTarget(epath string, schema *yangtree.SchemaNode, xpath string,
+	decode func(parent yangtree.DataNode) error) (yangtree.DataNode, error) {
+	pschema := dataParent(schema)
+	if pschema == nil {
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, epath, fmt.Errorf("no parent schema for %s", schema.Name))
+	}
+	parent, err := yangtree.New(pschema)
+	if err != nil {
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, epath, err)
+	}
+	if err := decode(parent); err != nil {
+		return nil, err
+	}
+	if parent.Len() != 1 {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "message-body must contain a single data resource")
+	}
+	node := parent.Child(0)
+	// The message-body MUST identify the target resource; the top-level node
+	// and the key leaf values in the message-body MUST be the same as the
+	// identifiers in the request URI.
+	if node.Schema() != schema {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			epath, fmt.Sprintf("message-body %s does not match the target resource %s",
+				node.Name(), schema.Name))
+	}
+	elems := splitXPath(xpath)
+	if found, err := yangtree.Find(parent, elems[len(elems)-1]); err != nil || len(found) != 1 {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			epath, "key values in message-body do not match the request URI")
+	}
+	if err := node.Remove(); err != nil {
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, epath, err)
+	}
+	return node, nil
+}
+
+// readTarget() decodes the message-body that represents the target data
+// resource and returns the detached target node.
+func (rc *RESTCtrl) readTarget(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) (yangtree.DataNode, error) {
+	return rc.decodeTarget(c.Path(), schema, xpath, func(parent yangtree.DataNode) error {
+		return rc.Unmarshal(c, parent)
+	})
+}
+
+// readDatastore() decodes the message-body that represents the datastore
+// resource and returns the data node of the datastore.
+func (rc *RESTCtrl) readDatastore(c *fiber.Ctx) (yangtree.DataNode, error) {
+	rnode, err := yangtree.New(rc.schemaRESTCONF)
+	if err != nil {
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	if err := rc.Unmarshal(c, rnode); err != nil {
+		return nil, err
+	}
+	data := rnode.Get("data")
+	if data == nil {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "message-body must contain the datastore resource")
+	}
+	return data, nil
+}
+
+// Post() creates a child data resource of the target data resource. (RFC8040 4.4.1)
+func (rc *RESTCtrl) Post(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) error {
+	target := rc.DataRoot
+	if schema != rc.schemaData {
+		if isMultiInstance(schema, xpath) {
+			return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "the request URI identifies multiple data resources")
+		}
+		found, err := yangtree.Find(rc.DataRoot, xpath)
+		if err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+		switch len(found) {
+		case 0:
+			return NewError(rc, fiber.StatusNotFound, ETypeApplication,
+				ETagDataMissing, c.Path(), "unable to find the parent of the new resource")
+		case 1:
+			target = found[0]
+		default:
+			return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "the request URI identifies multiple data resources")
+		}
+	}
+	if !target.IsBranchNode() {
+		return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), "unable to create a child of the leaf resource")
+	}
+	body, err := yangtree.New(schema)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	if err := rc.Unmarshal(c, body); err != nil {
+		return err
+	}
+	if body.Len() != 1 {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "message-body must contain a single child resource")
+	}
+	child := body.Child(0)
+	if child.IsStateNode() {
+		return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), "unable to create non-configuration data")
+	}
+	if target.Exist(child.ID()) {
+		return NewError(rc, fiber.StatusConflict, ETypeApplication,
+			ETagDataExists, c.Path(), fmt.Sprintf("%s already exists", child.ID()))
+	}
+	editopt, err := rc.InsertOption(c, child.Schema(), xpath)
+	if err != nil {
+		return err
+	}
+	if err := child.Remove(); err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	if _, err := target.Insert(child, editopt); err != nil {
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagInvalidValue, c.Path(), err)
+	}
+	c.Location(c.BaseURL() + "/restconf/data" + Node2RPath(child))
+	rc.revisions.Touch(childPath(xpath, child))
+	return rc.Response(c, &RespData{Status: fiber.StatusCreated})
+}
+
+// Put() creates or replaces the target data resource. (RFC8040 4.5)
+func (rc *RESTCtrl) Put(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) error {
+	if schema == rc.schemaData {
+		return rc.putDatastore(c)
+	}
+	if schema.IsState {
+		return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), "unable to edit non-configuration data")
+	}
+	if isMultiInstance(schema, xpath) {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+	// The key values in the message-body are checked against the request URI.
+	node, err := rc.readTarget(c, schema, xpath)
+	if err != nil {
+		return err
+	}
+	editopt, err := rc.InsertOption(c, schema, parentPath(xpath))
+	if err != nil {
+		return err
+	}
+	found, err := yangtree.Find(rc.DataRoot, xpath)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	switch len(found) {
+	case 0:
+		parent, created, err := getOrNewParent(rc.DataRoot, xpath)
+		if err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+		if _, err := parent.Insert(node, editopt); err != nil {
+			if created != nil {
+				created.Remove()
+			}
+			return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+				ETagInvalidValue, c.Path(), err)
+		}
+		rc.revisions.Touch(xpath)
+		return rc.Response(c, &RespData{Status: fiber.StatusCreated})
+	case 1:
+		old := found[0]
+		if schema.IsKey && old.ValueString() != node.ValueString() {
+			return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "unable to change the key leaf of the list entry")
+		}
+		// The entry of the ordered-by user list is replaced in place
+		// unless the insert parameter is present.
+		pos := positionOf(old)
+		if editopt == nil {
+			editopt = pos
+		}
+		parent := old.Parent()
+		if err := parent.Delete(old); err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+		if _, err := parent.Insert(node, editopt); err != nil {
+			if _, rerr := parent.Insert(old, pos); rerr != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagRollbackFailed, c.Path(), fmt.Sprintf("%v: unable to restore %s: %v", err, old.ID(), rerr))
+			}
+			return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+				ETagInvalidValue, c.Path(), err)
+		}
+		rc.revisions.Touch(xpath)
+		return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+	default:
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+}
+
+// putDatastore() replaces the configuration of the datastore with the data
+// resource in the message-body. The non-configuration data is preserved.
+func (rc *RESTCtrl) putDatastore(c *fiber.Ctx) error {
+	data, err := rc.readDatastore(c)
+	if err != nil {
+		return err
+	}
+	backup := yangtree.Clone(rc.DataRoot)
+	for _, child := range copyNodes(rc.DataRoot.Children()) {
+		if child.IsStateNode() {
+			continue
+		}
+		if err := rc.DataRoot.Delete(child); err != nil {
+			rc.DataRoot = backup
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+	}
+	for _, child := range copyNodes(data.Children()) {
+		if child.IsStateNode() {
+			continue
+		}
+		if err := child.Remove(); err != nil {
+			rc.DataRoot = backup
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+		if _, err := rc.DataRoot.Insert(child, nil); err != nil {
+			rc.DataRoot = backup
+			return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+				ETagInvalidValue, c.Path(), err)
+		}
+	}
+	rc.revisions.Touch("")
+	return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+}
+
+// restoreChild() replaces the child of the parent identified by the id with
+// the backup at the position of the edit option. The child is deleted if
+// the backup is nil.
+func restoreChild(parent yangtree.DataNode, id string, backup yangtree.DataNode, pos *yangtree.EditOption) error {
+	if child := parent.Get(id); child != nil {
+		if err := parent.Delete(child); err != nil {
+			return err
+		}
+	}
+	if backup == nil {
+		return nil
+	}
+	_, err := parent.Insert(backup, pos)
+	return err
+}
+
+// Patch() merges the message-body into the target data resource. (RFC8040 4.6.1)
+// The merge is discarded if it fails partway; only the data nodes to be
+// merged are copied to be restored.
+func (rc *RESTCtrl) Patch(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) error {
+	if schema == rc.schemaData {
+		return rc.patchDatastore(c)
+	}
+	if schema.IsState {
+		return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), "unable to edit non-configuration data")
+	}
+	if isMultiInstance(schema, xpath) {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+	found, err := yangtree.Find(rc.DataRoot, xpath)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	switch len(found) {
+	case 0:
+		return NewError(rc, fiber.StatusNotFound, ETypeApplication,
+			ETagDataMissing, c.Path(), "unable to find the target resource")
+	case 1:
+	default:
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+	node, err := rc.readTarget(c, schema, xpath)
+	if err != nil {
+		return err
+	}
+	target := found[0]
+	if schema.IsKey && target.ValueString() != node.ValueString() {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "unable to change the key leaf of the list entry")
+	}
+	parent, id := target.Parent(), target.ID()
+	backup, pos := yangtree.Clone(target), positionOf(target)
+	if err := target.Merge(node); err != nil {
+		if rerr := restoreChild(parent, id, backup, pos); rerr != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagRollbackFailed, c.Path(), fmt.Sprintf("%v: unable to restore %s: %v", err, id, rerr))
+		}
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagInvalidValue, c.Path(), err)
+	}
+	rc.revisions.Touch(xpath)
+	return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+}
+
+// patchDatastore() merges the configuration of the datastore resource in
+// the message-body into the datastore. The non-configuration data in the
+// message-body is ignored.
+func (rc *RESTCtrl) patchDatastore(c *fiber.Ctx) error {
+	data, err := rc.readDatastore(c)
+	if err != nil {
+		return err
+	}
+	// the top-level nodes merged and the copies to restore them.
+	var ids []string
+	backups := map[string]yangtree.DataNode{}
+	rollback := func(err error) error {
+		for _, id := range ids {
+			if rerr := restoreChild(rc.DataRoot, id, backups[id], nil); rerr != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagRollbackFailed, c.Path(), fmt.Sprintf("%v: unable to restore %s: %v", err, id, rerr))
+			}
+		}
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagInvalidValue, c.Path(), err)
+	}
+	for _, child := range copyNodes(data.Children()) {
+		if child.IsStateNode() {
+			continue
+		}
+		id := child.ID()
+		ids = append(ids, id)
+		dst := rc.DataRoot.Get(id)
+		if dst != nil {
+			backups[id] = yangtree.Clone(dst)
+			if err := dst.Merge(child); err != nil {
+				return rollback(err)
+			}
+			continue
+		}
+		if err := child.Remove(); err != nil {
+			return rollback(err)
+		}
+		if _, err := rc.DataRoot.Insert(child, nil); err != nil {
+			return rollback(err)
+		}
+	}
+	if len(ids) > 0 {
+		rc.revisions.Touch("")
+	}
+	return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+}
+
+// Delete() deletes the target data resource. (RFC8040 4.7)
+func (rc *RESTCtrl) Delete(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) error {
+	if schema == rc.schemaData {
+		if !rc.AllowDatastoreDelete {
+			return NewError(rc, fiber.StatusForbidden, ETypeProtocol,
+				ETagAccessDenied, c.Path(), "unable to delete the datastore resource")
+		}
+		for _, child := range copyNodes(rc.DataRoot.Children()) {
+			if child.IsStateNode() {
+				continue
+			}
+			if err := rc.DataRoot.Delete(child); err != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, c.Path(), err)
+			}
+		}
+		rc.revisions.Touch("")
+		return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+	}
+	if schema.IsState {
+		return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), "unable to delete non-configuration data")
+	}
+	if isMultiInstance(schema, xpath) {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+	found, err := yangtree.Find(rc.DataRoot, xpath)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	if len(found) == 0 {
+		return NewError(rc, fiber.StatusNotFound, ETypeApplication,
+			ETagDataMissing, c.Path(), "unable to find the target resource")
+	}
+	if schema.IsKey {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "unable to delete the key leaf of the list entry")
+	}
+	for i := range found {
+		if err := found[i].Remove(); err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+	}
+	rc.revisions.Touch(xpath)
+	return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+}
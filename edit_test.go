@@ -0,0 +1,406 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// newJukebox() returns the RESTCtrl of the jukebox data in JSON and the app
+// serving the RESTCONF resources of the RESTCtrl.
+func newJukebox(t *testing.T, data string) (*RESTCtrl, *fiber.App) {
+	t.Helper()
+	return newRESTCtrl(t, "modules/example/example-jukebox.yang", data)
+}
+
+// newRESTCtrl() returns the RESTCtrl of the yang file and the data in JSON
+// and the app serving the RESTCONF resources of the RESTCtrl.
+func newRESTCtrl(t *testing.T, file, data string) (*RESTCtrl, *fiber.App) {
+	t.Helper()
+	rc := loadSchema([]string{file}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "" {
+		if err := yangtree.UnmarshalJSON(root, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc.DataRoot = root
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteRESTCONF(app, rc); err != nil {
+		t.Fatal(err)
+	}
+	return rc, app
+}
+
+// doRequest() sends the request of the JSON message-body to the app.
+func doRequest(t *testing.T, app *fiber.App, method, path, body string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, "application/yang-data+json")
+	}
+	req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// marshalRoot() returns the datastore of the RESTCtrl in JSON.
+func marshalRoot(t *testing.T, rc *RESTCtrl) string {
+	t.Helper()
+	b, err := yangtree.MarshalJSON(rc.DataRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// songOrder() returns the indexes of the songs of the playlist in order.
+func songOrder(t *testing.T, rc *RESTCtrl, playlist string) []string {
+	t.Helper()
+	found, err := yangtree.Find(rc.DataRoot, "jukebox/playlist[name="+playlist+"]/song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for i := range found {
+		order = append(order, found[i].GetValueString("index"))
+	}
+	return order
+}
+
+func Test_splitXPath(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  []string
+	}{
+		{xpath: "", want: nil},
+		{xpath: "a/b", want: []string{"a", "b"}},
+		{xpath: "a/b[name=1/1]/c", want: []string{"a", "b[name=1/1]", "c"}},
+		{xpath: "a/b[name='x]/y']/c", want: []string{"a", "b[name='x]/y']", "c"}},
+		{xpath: `a/b[.="it's/[1]"]`, want: []string{"a", `b[.="it's/[1]"]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.xpath, func(t *testing.T) {
+			if got := splitXPath(tt.xpath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitXPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Patch(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		status  int
+		find    string // the xpath found after the request
+		missing string // the xpath not found after the request
+	}{
+		{name: "merge", path: "/example-jukebox:jukebox/library",
+			body:   `{"example-jukebox:library":{"artist":[{"name":"A","album":[{"name":"X","year":2011}]},{"name":"B"}]}}`,
+			status: fiber.StatusNoContent, find: "jukebox/library/artist[name=B]"},
+		{name: "bad value in a later sibling", path: "/example-jukebox:jukebox/library",
+			body:   `{"example-jukebox:library":{"artist":[{"name":"B"},{"name":"A","album":[{"name":"X","year":1800}]}]}}`,
+			status: fiber.StatusBadRequest},
+		{name: "keyless list", path: "/example-jukebox:jukebox/library/artist",
+			body:   `{"example-jukebox:artist":[{"name":"A","album":[{"name":"X"}]}]}`,
+			status: fiber.StatusBadRequest},
+		{name: "datastore", path: "",
+			body: `{"ietf-restconf:data":{"example-jukebox:jukebox":{"library":{"artist":[{"name":"B"}]}},
+				"ietf-yang-library:modules-state":{"module-set-id":"injected"}}}`,
+			status: fiber.StatusNoContent, find: "jukebox/library/artist[name=B]", missing: "modules-state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]}}}`)
+			before := marshalRoot(t, rc)
+			resp := doRequest(t, app, "PATCH", "/restconf/data"+tt.path, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode >= fiber.StatusBadRequest {
+				if after := marshalRoot(t, rc); after != before {
+					t.Errorf("the datastore changed by the failed PATCH:\n%s\n%s", before, after)
+				}
+				return
+			}
+			if found, err := yangtree.Find(rc.DataRoot, tt.find); err != nil || len(found) != 1 {
+				t.Errorf("%s not found after PATCH: %v", tt.find, err)
+			}
+			if tt.missing != "" {
+				if found, _ := yangtree.Find(rc.DataRoot, tt.missing); len(found) != 0 {
+					t.Errorf("non-configuration %s written by PATCH", tt.missing)
+				}
+			}
+		})
+	}
+}
+
+func Test_LeafList(t *testing.T) {
+	const data = `{"example-leaflist:system":{"server":["a/b","it's","x"]}}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string   // the substring of the response body
+		want   []string // the servers after the request
+	}{
+		{name: "get", method: "GET", path: "/example-leaflist:system/server=a%2Fb",
+			status: fiber.StatusOK, body: `"a/b"`, want: []string{"a/b", "it's", "x"}},
+		{name: "get quoted", method: "GET", path: "/example-leaflist:system/server=it's",
+			status: fiber.StatusOK, body: `"it's"`, want: []string{"a/b", "it's", "x"}},
+		{name: "get missing", method: "GET", path: "/example-leaflist:system/server=y",
+			status: fiber.StatusNotFound, want: []string{"a/b", "it's", "x"}},
+		{name: "delete", method: "DELETE", path: "/example-leaflist:system/server=a%2Fb",
+			status: fiber.StatusNoContent, want: []string{"it's", "x"}},
+		{name: "delete quoted", method: "DELETE", path: "/example-leaflist:system/server=it's",
+			status: fiber.StatusNoContent, want: []string{"a/b", "x"}},
+		{name: "delete all", method: "DELETE", path: "/example-leaflist:system/server",
+			status: fiber.StatusBadRequest, want: []string{"a/b", "it's", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newRESTCtrl(t, "testdata/example-leaflist.yang", data)
+			resp := doRequest(t, app, tt.method, "/restconf/data"+tt.path, "")
+			if resp.StatusCode != tt.status {
+				t.Fatalf("%s status = %d, want %d", tt.method, resp.StatusCode, tt.status)
+			}
+			if tt.body != "" {
+				b, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(b), tt.body) {
+					t.Errorf("%s body = %s, want %s", tt.method, b, tt.body)
+				}
+			}
+			found, err := yangtree.Find(rc.DataRoot, "system/server")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var servers []string
+			for i := range found {
+				servers = append(servers, found[i].ValueString())
+			}
+			if !reflect.DeepEqual(servers, tt.want) {
+				t.Errorf("servers = %v, want %v", servers, tt.want)
+			}
+		})
+	}
+}
+
+func Test_DeleteMultiInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		changed bool
+	}{
+		{name: "all artists", path: "/example-jukebox:jukebox/library/artist", status: fiber.StatusBadRequest},
+		{name: "all albums", path: "/example-jukebox:jukebox/library/artist=A/album", status: fiber.StatusBadRequest},
+		{name: "an artist", path: "/example-jukebox:jukebox/library/artist=A", status: fiber.StatusNoContent, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, `{"example-jukebox:jukebox":{"library":{"artist":[
+				{"name":"A","album":[{"name":"X"},{"name":"Y"}]},{"name":"B"}]}}}`)
+			before := marshalRoot(t, rc)
+			resp := doRequest(t, app, "DELETE", "/restconf/data"+tt.path, "")
+			if resp.StatusCode != tt.status {
+				t.Errorf("DELETE status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if changed := marshalRoot(t, rc) != before; changed != tt.changed {
+				t.Errorf("datastore changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func Test_Put(t *testing.T) {
+	const data = `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]},
+		"playlist":[{"name":"p","song":[
+			{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":2,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":3,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]}]}}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		find   string   // the xpath found after the request
+		order  []string // the indexes of the playlist songs after the request
+	}{
+		{name: "create", path: "/example-jukebox:jukebox/library/artist=B",
+			body:   `{"example-jukebox:artist":[{"name":"B"}]}`,
+			status: fiber.StatusCreated, find: "jukebox/library/artist[name=B]"},
+		{name: "create ancestors", path: "/example-jukebox:jukebox/player/gap",
+			body:   `{"example-jukebox:gap":"0.5"}`,
+			status: fiber.StatusCreated, find: "jukebox/player/gap"},
+		{name: "replace", path: "/example-jukebox:jukebox/library/artist=A",
+			body:   `{"example-jukebox:artist":[{"name":"A","album":[{"name":"X"}]}]}`,
+			status: fiber.StatusNoContent, find: "jukebox/library/artist[name=A]/album[name=X]"},
+		{name: "replace in place", path: "/example-jukebox:jukebox/playlist=p/song=2",
+			body:   `{"example-jukebox:song":[{"index":2,"id":"/example-jukebox:jukebox/library/artist[name='B']"}]}`,
+			status: fiber.StatusNoContent, order: []string{"1", "2", "3"}},
+		{name: "key mismatch", path: "/example-jukebox:jukebox/library/artist=A",
+			body:   `{"example-jukebox:artist":[{"name":"B"}]}`,
+			status: fiber.StatusBadRequest},
+		{name: "keyless list", path: "/example-jukebox:jukebox/library/artist",
+			body:   `{"example-jukebox:artist":[{"name":"B"}]}`,
+			status: fiber.StatusBadRequest},
+		{name: "keyless list of an entry", path: "/example-jukebox:jukebox/library/artist",
+			body:   `{"example-jukebox:artist":[{"name":"A"}]}`,
+			status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, data)
+			before := marshalRoot(t, rc)
+			resp := doRequest(t, app, "PUT", "/restconf/data"+tt.path, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("PUT status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode >= fiber.StatusBadRequest {
+				if after := marshalRoot(t, rc); after != before {
+					t.Errorf("the datastore changed by the failed PUT:\n%s\n%s", before, after)
+				}
+				return
+			}
+			if tt.find != "" {
+				if found, err := yangtree.Find(rc.DataRoot, tt.find); err != nil || len(found) != 1 {
+					t.Errorf("%s not found after PUT: %v", tt.find, err)
+				}
+			}
+			if tt.order != nil {
+				if order := songOrder(t, rc, "p"); !reflect.DeepEqual(order, tt.order) {
+					t.Errorf("songs = %v, want %v", order, tt.order)
+				}
+			}
+		})
+	}
+}
+
+func Test_Post(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		key    string // the name of the artist created
+	}{
+		{name: "artist", path: "/example-jukebox:jukebox/library",
+			body: `{"example-jukebox:artist":[{"name":"Foo Fighters"}]}`, status: fiber.StatusCreated, key: "Foo Fighters"},
+		{name: "reserved characters", path: "/example-jukebox:jukebox/library",
+			body: `{"example-jukebox:artist":[{"name":"a,b/c'd"}]}`, status: fiber.StatusCreated, key: "a,b/c'd"},
+		{name: "existing artist", path: "/example-jukebox:jukebox/library",
+			body: `{"example-jukebox:artist":[{"name":"A"}]}`, status: fiber.StatusConflict},
+		{name: "keyless list", path: "/example-jukebox:jukebox/library/artist",
+			body: `{"example-jukebox:album":[{"name":"X"}]}`, status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]}}}`)
+			before := marshalRoot(t, rc)
+			resp := doRequest(t, app, "POST", "/restconf/data"+tt.path, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode != fiber.StatusCreated {
+				if after := marshalRoot(t, rc); after != before {
+					t.Errorf("the datastore changed by the failed POST:\n%s\n%s", before, after)
+				}
+				return
+			}
+			// The Location header identifies the resource created.
+			location := resp.Header.Get(fiber.HeaderLocation)
+			i := strings.Index(location, "/restconf/data/")
+			if i < 0 {
+				t.Fatalf("Location = %q", location)
+			}
+			rpath := location[i+len("/restconf/data"):]
+			_, xpath, err := RPath2XPath(rc.schemaData, &rpath)
+			if err != nil {
+				t.Fatalf("RPath2XPath(%s) error = %v", rpath, err)
+			}
+			found, err := yangtree.Find(rc.DataRoot, xpath)
+			if err != nil || len(found) != 1 {
+				t.Fatalf("Location %s not found: %v", location, err)
+			}
+			if got := found[0].GetValueString("name"); got != tt.key {
+				t.Errorf("Location %s identifies %q, want %q", location, got, tt.key)
+			}
+			if resp := doRequest(t, app, "GET", location[i:], ""); resp.StatusCode != fiber.StatusOK {
+				t.Errorf("GET %s status = %d, want %d", location[i:], resp.StatusCode, fiber.StatusOK)
+			}
+		})
+	}
+}
+
+func Test_Insert(t *testing.T) {
+	const data = `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]},
+		"playlist":[{"name":"p","song":[
+			{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":2,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":3,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]},
+		{"name":"q","song":[
+			{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]}]}}`
+	const song = `{"example-jukebox:song":[{"index":4,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]}`
+	tests := []struct {
+		name   string
+		path   string
+		query  string
+		body   string
+		status int
+		order  []string // the indexes of the songs of the playlist p after the request
+	}{
+		{name: "first", path: "/example-jukebox:jukebox/playlist=p", query: "insert=first",
+			body: song, status: fiber.StatusCreated, order: []string{"4", "1", "2", "3"}},
+		{name: "last", path: "/example-jukebox:jukebox/playlist=p", query: "insert=last",
+			body: song, status: fiber.StatusCreated, order: []string{"1", "2", "3", "4"}},
+		{name: "before", path: "/example-jukebox:jukebox/playlist=p",
+			query: "insert=before&point=" + url.QueryEscape("/restconf/data/example-jukebox:jukebox/playlist=p/song=2"),
+			body:  song, status: fiber.StatusCreated, order: []string{"1", "4", "2", "3"}},
+		{name: "after", path: "/example-jukebox:jukebox/playlist=p",
+			query: "insert=after&point=" + url.QueryEscape("/restconf/data/example-jukebox:jukebox/playlist=p/song=2"),
+			body:  song, status: fiber.StatusCreated, order: []string{"1", "2", "4", "3"}},
+		{name: "point in another parent", path: "/example-jukebox:jukebox/playlist=p",
+			query: "insert=before&point=" + url.QueryEscape("/restconf/data/example-jukebox:jukebox/playlist=q/song=1"),
+			body:  song, status: fiber.StatusBadRequest},
+		{name: "not ordered-by user", path: "/example-jukebox:jukebox/library", query: "insert=first",
+			body: `{"example-jukebox:artist":[{"name":"B"}]}`, status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, data)
+			before := marshalRoot(t, rc)
+			resp := doRequest(t, app, "POST", "/restconf/data"+tt.path+"?"+tt.query, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode >= fiber.StatusBadRequest {
+				if after := marshalRoot(t, rc); after != before {
+					t.Errorf("the datastore changed by the failed POST:\n%s\n%s", before, after)
+				}
+				return
+			}
+			if order := songOrder(t, rc, "p"); !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("songs = %v, want %v", order, tt.order)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"strings"
 
@@ -181,7 +182,7 @@ func (et ErrorTag) Status() int {
 		// return fiber.StatusMethodNotAllowed
 		return fiber.StatusNotImplemented
 	case ETagOperationFailed:
-		// return fiber.StatusPreconditionFailed
+		// 412 is mapped by PreconditionStatus().
 		return fiber.StatusInternalServerError
 	case ETagPartialOperation:
 		return fiber.StatusInternalServerError
@@ -192,6 +193,16 @@ func (et ErrorTag) Status() int {
 	}
 }
 
+// PreconditionStatus() returns a HTTP code according to the Tag for the
+// precondition of the conditional request (RFC7232) evaluated to false.
+// operation-failed is mapped to 412 instead of 500.
+func (et ErrorTag) PreconditionStatus() int {
+	if et == ETagOperationFailed {
+		return fiber.StatusPreconditionFailed
+	}
+	return et.Status()
+}
+
 func errhandler(c *fiber.Ctx, err error) error {
 	if e, ok := err.(*RespError); ok {
 		return e.Response(c)
@@ -262,6 +273,17 @@ func (re *RespError) Add(rc *RESTCtrl, code int, etyp ErrorType, etag ErrorTag,
 	return re
 }
 
+// SetAppTag() sets the error-app-tag of the last error of the RespError.
+func (re *RespError) SetAppTag(apptag string) *RespError {
+	if re == nil || len(re.Errors) == 0 || apptag == "" {
+		return re
+	}
+	if err := yangtree.SetValue(re.Errors[len(re.Errors)-1], "error-app-tag", nil, apptag); err != nil {
+		log.Fatalf("restconf: fault in error report: %v", err)
+	}
+	return re
+}
+
 func (re *RespError) Error() string {
 	if len(re.Errors) > 0 {
 		errorsSchema := re.Errors[0].Schema().Parent
@@ -284,6 +306,40 @@ func (re *RespError) Error() string {
 	return "unspecified error"
 }
 
+// Convert() returns a new errors container node of the errorsSchema that
+// contains all errors of the RespError. It is used to report the errors
+// in other YANG-modeled data such as yang-patch-status.
+func (re *RespError) Convert(errorsSchema *yangtree.SchemaNode) (yangtree.DataNode, error) {
+	if errorsSchema == nil {
+		return nil, fmt.Errorf("errors schema not specified")
+	}
+	enode, err := yangtree.New(errorsSchema)
+	if err != nil {
+		return nil, err
+	}
+	errorSchema := errorsSchema.GetSchema("error")
+	if errorSchema == nil {
+		return nil, fmt.Errorf("error schema not found in %s", errorsSchema.Name)
+	}
+	for i := range re.Errors {
+		b, err := yangtree.MarshalJSON(re.Errors[i])
+		if err != nil {
+			return nil, err
+		}
+		e, err := yangtree.New(errorSchema)
+		if err != nil {
+			return nil, err
+		}
+		if err := yangtree.UnmarshalJSON(e, b); err != nil {
+			return nil, err
+		}
+		if _, err := enode.Insert(e, nil); err != nil {
+			return nil, err
+		}
+	}
+	return enode, nil
+}
+
 func (re *RespError) Response(c *fiber.Ctx) error {
 	var marshal func(node yangtree.DataNode, prefix, indent string, option ...yangtree.Option) ([]byte, error)
 
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// RFC8040 3.5.1. Timestamp, 3.5.2. Entity-Tag
+
+// Revision is the revision of a data resource updated by an edit.
+type Revision struct {
+	Counter  uint64    // the revision counter of the datastore
+	Modified time.Time // the last modified time
+}
+
+// Revisions keeps the revisions of the edited data resources to generate
+// the entity-tag and the last modified time of a data resource. The
+// revisions are kept in a tree of the data path elements, and the revisions
+// of the descendants are pruned when the ancestor is edited.
+type Revisions struct {
+	sync.RWMutex
+	boot    time.Time     // the server start time to distinguish the entity-tags
+	counter uint64        // the revision counter of the datastore
+	root    *revisionNode // the revisions of the edited data paths
+	last    Revision      // the datastore-wide revision
+}
+
+// revisionNode is the node of the revision tree.
+type revisionNode struct {
+	edit     Revision // the revision of the edit of the node itself
+	latest   Revision // the latest revision of the node and its descendants
+	children map[string]*revisionNode
+}
+
+// NewRevisions() returns the Revisions of the datastore.
+func NewRevisions() *Revisions {
+	now := time.Now()
+	return &Revisions{
+		boot: now,
+		root: &revisionNode{},
+		last: Revision{Modified: now},
+	}
+}
+
+// revisionPath() returns the elements of the data path used to key the
+// revisions. The module prefixes are removed and the key values of the
+// predicates are unquoted and re-quoted by xpathValue(), so that the same
+// data resource has the same elements regardless of the quoting of the
+// data path. The predicates of a list or leaf-list entry are an element
+// following the element of the list or leaf-list name.
+func revisionPath(xpath string) []string {
+	var elems []string
+	for _, elem := range splitXPath(xpath) {
+		name, preds := elem, ""
+		if i := strings.IndexByte(elem, '['); i >= 0 {
+			name, preds = elem[:i], elem[i:]
+		}
+		if i := strings.LastIndexByte(name, ':'); i >= 0 {
+			name = name[i+1:]
+		}
+		elems = append(elems, name)
+		if preds != "" {
+			elems = append(elems, canonicalPredicates(preds))
+		}
+	}
+	return elems
+}
+
+// canonicalPredicates() returns the predicates ([key=value]...) of which
+// the values are quoted by xpathValue(). The predicates are returned as
+// they are if they are not able to be parsed.
+func canonicalPredicates(preds string) string {
+	var b strings.Builder
+	for rest := preds; rest != ""; {
+		eq := strings.IndexByte(rest, '=')
+		if rest[0] != '[' || eq < 0 {
+			return preds
+		}
+		key, value := rest[1:eq], rest[eq+1:]
+		var end int // the index of the closing bracket in the value
+		if value != "" && (value[0] == '\'' || value[0] == '"') {
+			q := strings.IndexByte(value[1:], value[0])
+			if q < 0 || len(value) < q+3 || value[q+2] != ']' {
+				return preds
+			}
+			end = q + 2
+			value, rest = value[1:q+1], value[end+1:]
+		} else {
+			if end = strings.IndexByte(value, ']'); end < 0 {
+				return preds
+			}
+			value, rest = value[:end], value[end+1:]
+		}
+		qvalue, err := xpathValue(value)
+		if err != nil {
+			return preds
+		}
+		b.WriteString("[" + strings.TrimSpace(key) + "=" + qvalue + "]")
+	}
+	return b.String()
+}
+
+// Touch() updates the revision of the data resource of the xpath
+// edited successfully. The empty xpath indicates the datastore.
+func (r *Revisions) Touch(xpath string) {
+	r.Lock()
+	defer r.Unlock()
+	r.counter++
+	r.last = Revision{Counter: r.counter, Modified: time.Now()}
+	n := r.root
+	for _, elem := range revisionPath(xpath) {
+		n.latest = r.last
+		child, ok := n.children[elem]
+		if !ok {
+			if n.children == nil {
+				n.children = map[string]*revisionNode{}
+			}
+			child = &revisionNode{}
+			n.children[elem] = child
+		}
+		n = child
+	}
+	// the revisions of the descendants are older than the edit.
+	n.edit, n.latest, n.children = r.last, r.last, nil
+}
+
+// Get() returns the revision of the data resource of the xpath. The revision
+// of the data resource is the last edit of the resource, its descendants
+// and ancestors that replace the resource.
+func (r *Revisions) Get(xpath string) Revision {
+	r.RLock()
+	defer r.RUnlock()
+	if xpath == "" {
+		return r.last
+	}
+	rev := Revision{Modified: r.boot}
+	newer := func(e Revision) {
+		if e.Counter > rev.Counter {
+			rev = e
+		}
+	}
+	n := r.root
+	newer(n.edit)
+	for _, elem := range revisionPath(xpath) {
+		if n = n.children[elem]; n == nil {
+			return rev
+		}
+		newer(n.edit)
+	}
+	newer(n.latest)
+	return rev
+}
+
+// ETag() returns the entity-tag of the revision.
+func (r *Revisions) ETag(rev Revision) string {
+	return fmt.Sprintf(`"%x-%x"`, r.boot.Unix(), rev.Counter)
+}
+
+// SetEntityTag() sets the ETag and Last-Modified headers of the response
+// for the data resource of the xpath.
+func (rc *RESTCtrl) SetEntityTag(c *fiber.Ctx, xpath string) {
+	rev := rc.revisions.Get(xpath)
+	c.Set("ETag", rc.revisions.ETag(rev))
+	c.Set("Last-Modified", rev.Modified.UTC().Format(http.TimeFormat))
+}
+
+// matchETag() returns true if the entity-tag is listed in the If-Match or
+// If-None-Match header value. "*" matches any entity-tag. The weak comparison
+// ignores the weakness indicator "W/" of the listed entity-tags.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPreconditions() evaluates the conditional request headers (RFC7232 6.)
+// of the request against the entity-tag and the last modified time of the
+// data resource of the xpath. It returns true if the GET or HEAD request
+// needs to be answered with "304 Not Modified" and returns the error
+// (412 Precondition Failed) if the precondition of the request is false.
+func (rc *RESTCtrl) CheckPreconditions(c *fiber.Ctx, xpath string) (bool, error) {
+	ifMatch := c.Get("If-Match")
+	ifNoneMatch := c.Get("If-None-Match")
+	ifModifiedSince := c.Get("If-Modified-Since")
+	ifUnmodifiedSince := c.Get("If-Unmodified-Since")
+	if ifMatch == "" && ifNoneMatch == "" && ifModifiedSince == "" && ifUnmodifiedSince == "" {
+		return false, nil
+	}
+	exists := xpath == ""
+	if !exists {
+		found, err := yangtree.Find(rc.DataRoot, xpath)
+		exists = err == nil && len(found) > 0
+	}
+	rev := rc.revisions.Get(xpath)
+	etag := rc.revisions.ETag(rev)
+	modified := rev.Modified.Truncate(time.Second)
+	read := c.Method() == "GET" || c.Method() == "HEAD"
+	failed := func(msg string) (bool, error) {
+		return false, NewError(rc, ETagOperationFailed.PreconditionStatus(), ETypeProtocol,
+			ETagOperationFailed, c.Path(), msg)
+	}
+
+	if ifMatch != "" {
+		if !exists || !matchETag(ifMatch, etag, false) {
+			return failed("If-Match precondition failed")
+		}
+	} else if ifUnmodifiedSince != "" {
+		if t, err := http.ParseTime(ifUnmodifiedSince); err == nil && modified.After(t) {
+			return failed("If-Unmodified-Since precondition failed")
+		}
+	}
+	if ifNoneMatch != "" {
+		if (exists || strings.TrimSpace(ifNoneMatch) != "*") && matchETag(ifNoneMatch, etag, true) {
+			if read {
+				return true, nil
+			}
+			return failed("If-None-Match precondition failed")
+		}
+	} else if ifModifiedSince != "" && read {
+		if t, err := http.ParseTime(ifModifiedSince); err == nil && !modified.After(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_Revisions(t *testing.T) {
+	r := NewRevisions()
+	boot := r.Get("jukebox/library/artist[name=Foo]")
+	r.Touch("jukebox/library/artist[name=Foo]/album[name=Bar]")
+	r.Touch("jukebox/playlist[name=One]")
+
+	tests := []struct {
+		xpath string
+		want  uint64
+	}{
+		{xpath: "", want: 2},
+		{xpath: "jukebox", want: 2},
+		{xpath: "jukebox/library", want: 1},
+		{xpath: "jukebox/library/artist", want: 1},
+		{xpath: "jukebox/library/artist[name=Foo]/album[name=Bar]/year", want: 1},
+		{xpath: "jukebox/library/artist[name=Foos]", want: 0},
+		{xpath: "jukebox/playlist[name=One]", want: 2},
+		{xpath: "jukebox/playlist[name=Two]", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.xpath, func(t *testing.T) {
+			if got := r.Get(tt.xpath); got.Counter != tt.want {
+				t.Errorf("Get() = %d, want %d", got.Counter, tt.want)
+			}
+		})
+	}
+	if r.ETag(boot) == r.ETag(r.Get("jukebox")) {
+		t.Errorf("ETag() not changed by the edit")
+	}
+	r.Touch("")
+	if got := r.Get("jukebox/playlist[name=Two]"); got.Counter != 3 {
+		t.Errorf("Get() = %d after the datastore edit, want 3", got.Counter)
+	}
+}
+
+func Test_matchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{header: `"1-2"`, want: true},
+		{header: `"1-1", "1-2"`, want: true},
+		{header: `"1-1"`, want: false},
+		{header: `*`, want: true},
+		{header: `W/"1-2"`, want: false},
+		{header: `W/"1-2"`, weak: true, want: true},
+		{header: `"1-2-3"`, weak: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := matchETag(tt.header, `"1-2"`, tt.weak); got != tt.want {
+				t.Errorf("matchETag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_revisionPath(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  []string
+	}{
+		{xpath: "jukebox/library/artist[name=Foo Fighters]",
+			want: []string{"jukebox", "library", "artist", "[name='Foo Fighters']"}},
+		{xpath: "jukebox/library/artist[name='Foo Fighters']/album[name=Bar]",
+			want: []string{"jukebox", "library", "artist", "[name='Foo Fighters']", "album", "[name=Bar]"}},
+		{xpath: `example-top:top/list1[key1="it's"][key2=a/b]`,
+			want: []string{"top", "list1", `[key1="it's"][key2='a/b']`}},
+		{xpath: "top/leaflist[.='x']", want: []string{"top", "leaflist", "[.=x]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.xpath, func(t *testing.T) {
+			if got := revisionPath(tt.xpath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("revisionPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	r := NewRevisions()
+	r.Touch("jukebox/library/artist[name=Foo Fighters]/album[name=Bar]")
+	r.Touch("jukebox/library")
+	if n := r.root.children["jukebox"].children["library"]; len(n.children) != 0 {
+		t.Errorf("the revisions of the descendants not pruned: %d", len(n.children))
+	}
+	if got := r.Get("jukebox/library/artist[name='Foo Fighters']"); got.Counter != 2 {
+		t.Errorf("Get() = %d, want 2", got.Counter)
+	}
+}
+
+func Test_EntityTagAfterPost(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yangtree.UnmarshalJSON(root,
+		[]byte(`{"example-jukebox:jukebox":{"library":{"artist":[{"name":"Other"}]}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteRESTCONF(app, rc); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body, ifMatch string) *http.Response {
+		req := httptest.NewRequest(method, "/restconf/data/example-jukebox:jukebox/library"+path,
+			strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, "application/yang-data+json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := do("POST", "", `{"example-jukebox:artist":[{"name":"Foo Fighters"}]}`, ""); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("POST artist status = %d", resp.StatusCode)
+	}
+	library := do("GET", "", "", "").Header.Get("ETag")
+	etag := do("GET", "/artist=Foo%20Fighters", "", "").Header.Get("ETag")
+	if etag != library {
+		t.Errorf("ETag of the artist created = %s, want %s of the last edit", etag, library)
+	}
+	if resp := do("POST", "/artist=Foo%20Fighters", `{"example-jukebox:album":[{"name":"Wasting Light"}]}`, ""); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("POST album status = %d", resp.StatusCode)
+	}
+	if resp := do("DELETE", "/artist=Foo%20Fighters", "", etag); resp.StatusCode != fiber.StatusPreconditionFailed {
+		t.Errorf("DELETE with the stale If-Match status = %d, want %d", resp.StatusCode, fiber.StatusPreconditionFailed)
+	}
+	etag = do("GET", "/artist=Foo%20Fighters", "", "").Header.Get("ETag")
+	if resp := do("DELETE", "/artist=Foo%20Fighters", "", etag); resp.StatusCode != fiber.StatusNoContent {
+		t.Errorf("DELETE with the current If-Match status = %d, want %d", resp.StatusCode, fiber.StatusNoContent)
+	}
+}
+
+func Test_CheckPreconditions(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yangtree.SetValue(root, "restconf-state/capabilities/capability", nil, capabilities[0]); err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	rc.revisions.Touch("restconf-state")
+	etag := rc.revisions.ETag(rc.revisions.Get("restconf-state"))
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	app.All("/", func(c *fiber.Ctx) error {
+		notModified, err := rc.CheckPreconditions(c, "restconf-state")
+		if err != nil {
+			return err
+		}
+		if notModified {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "If-Match current", method: "PUT", header: "If-Match", value: etag, want: fiber.StatusOK},
+		{name: "If-Match stale", method: "PUT", header: "If-Match", value: `"0-0"`, want: fiber.StatusPreconditionFailed},
+		{name: "If-None-Match * on GET", method: "GET", header: "If-None-Match", value: "*", want: fiber.StatusNotModified},
+		{name: "If-None-Match * on PUT", method: "PUT", header: "If-None-Match", value: "*", want: fiber.StatusPreconditionFailed},
+		{name: "If-None-Match stale", method: "GET", header: "If-None-Match", value: `"0-0"`, want: fiber.StatusOK},
+		{name: "If-Modified-Since not modified", method: "GET", header: "If-Modified-Since", value: future, want: fiber.StatusNotModified},
+		{name: "If-Modified-Since modified", method: "GET", header: "If-Modified-Since", value: past, want: fiber.StatusOK},
+		{name: "If-Unmodified-Since modified", method: "PUT", header: "If-Unmodified-Since", value: past, want: fiber.StatusPreconditionFailed},
+		{name: "If-Unmodified-Since not modified", method: "PUT", header: "If-Unmodified-Since", value: future, want: fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set(tt.header, tt.value)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// Executor runs the rpc and action handlers as external executables.
+// The input of the rpc or action encoded in RFC7951 JSON is written to the
+// stdin of the executable, and the stdout of the executable is decoded as
+// the output ({"module-name:output": {...}}). A non-zero exit code is
+// reported as the error of the rpc or action with the message in the stderr.
+//
+// The following environment variables are set to the executable.
+//
+//	RESTCONF_OPERATION: the schema path of the rpc or action
+//	RESTCONF_TARGET: the data resource URI of the action
+//	RESTCONF_USER: the authenticated user
+type Executor struct {
+	Timeout time.Duration // the timeout of an execution: no timeout if zero
+	slots   chan struct{} // limits the number of concurrent executions
+}
+
+// execWaitDelay is the time to wait for the I/O of the executable to be
+// closed after the executable exits or is killed.
+const execWaitDelay = time.Second
+
+// NewExecutor() returns the Executor that runs max executables concurrently.
+func NewExecutor(timeout time.Duration, max int) *Executor {
+	if max <= 0 {
+		max = 1
+	}
+	return &Executor{Timeout: timeout, slots: make(chan struct{}, max)}
+}
+
+// RegisterExec() registers the executable command as the handler of the rpc
+// or action identified by the schema path. The command is split into the
+// executable and the arguments by white spaces without quoting, so an
+// argument containing white spaces must be passed by a wrapper script. The
+// opts are applied to the registration as RegisterRPC() and RegisterAction().
+func (rc *RESTCtrl) RegisterExec(path, command string, e *Executor, opts ...RPCOption) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("restconf: empty executable for %s", path)
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("restconf: unable to register %s: %v", path, err)
+	}
+	handler := e.handler(rc, args)
+	if schema, err := findSchema(rc.schemaOperations, path); err == nil && schema.RPC != nil {
+		return rc.RegisterRPC(path, handler, opts...)
+	}
+	return rc.RegisterAction(path, handler, opts...)
+}
+
+// handler() returns the RPCHandler running the executable of the args.
+func (e *Executor) handler(rc *RESTCtrl, args []string) RPCHandler {
+	return func(req *RPCRequest) (yangtree.DataNode, error) {
+		epath := req.Path
+		ctx := context.Background()
+		if req.Job != nil {
+			// The executable is killed if the job is canceled.
+			ctx = req.Job.Context()
+		}
+		// The time waiting for a slot is limited by the timeout separately;
+		// the timeout of the execution starts after the slot is acquired.
+		var wait <-chan time.Time
+		if e.Timeout > 0 {
+			timer := time.NewTimer(e.Timeout)
+			defer timer.Stop()
+			wait = timer.C
+		}
+		select {
+		case e.slots <- struct{}{}:
+			defer func() { <-e.slots }()
+		case <-wait:
+			return nil, NewError(rc, fiber.StatusServiceUnavailable, ETypeApplication,
+				ETagResourceDenied, epath, "too many operations in progress")
+		case <-ctx.Done():
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, fmt.Sprintf("%s canceled", args[0]))
+		}
+		if e.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+			defer cancel()
+		}
+
+		var stdin []byte
+		if req.Input != nil {
+			b, err := yangtree.MarshalJSON(req.Input, yangtree.RepresentItself{})
+			if err != nil {
+				return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, epath, err)
+			}
+			stdin = b
+		}
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		// Wait() returns after the delay even if a child process of the
+		// executable keeps the stdout or stderr open.
+		cmd.WaitDelay = execWaitDelay
+		// The executable runs in its own process group so that the timeout
+		// and the cancel kill the child processes of the executable as well.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		if req.Target != nil {
+			var target string
+			req.Data.Read(func(root yangtree.DataNode) error {
+				if found, err := yangtree.Find(root, req.TargetPath); err == nil && len(found) == 1 {
+					target = "/restconf/data" + Node2RPath(found[0])
+				}
+				return nil
+			})
+			cmd.Env = append(os.Environ(),
+				"RESTCONF_OPERATION="+Schema2RPath(rc.schemaData, req.Schema),
+				"RESTCONF_TARGET="+target)
+		} else {
+			cmd.Env = append(os.Environ(),
+				"RESTCONF_OPERATION="+Schema2RPath(rc.schemaOperations, req.Schema))
+		}
+		cmd.Env = append(cmd.Env, "RESTCONF_USER="+req.User)
+		err := cmd.Run()
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, fmt.Sprintf("%s timed out after %v", args[0], e.Timeout))
+		case context.Canceled:
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, fmt.Sprintf("%s canceled", args[0]))
+		}
+		// ErrWaitDelay is reported only if the executable exited successfully.
+		if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+			msg := fmt.Sprintf("%s failed: %v", args[0], err)
+			if detail := strings.TrimSpace(stderr.String()); detail != "" {
+				msg += ": " + detail
+			}
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, msg)
+		}
+		if len(bytes.TrimSpace(stdout.Bytes())) == 0 || !req.Schema.HasRPCOutput() {
+			return nil, nil
+		}
+		rpc, err := yangtree.New(req.Schema)
+		if err != nil {
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, err)
+		}
+		if err := yangtree.UnmarshalJSON(rpc, stdout.Bytes()); err != nil {
+			return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, fmt.Sprintf("invalid output of %s: %v", args[0], err))
+		}
+		return rpc.Get("output"), nil
+	}
+}
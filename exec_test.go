@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_ExecJobCancel(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-exec.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	tmp := t.TempDir()
+	pidfile := filepath.Join(tmp, "pid")
+	script := filepath.Join(tmp, "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho $$ > "+pidfile+"\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.RegisterAction("/open-restconf-jobs:jobs/job/cancel", rc.CancelJob); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.RegisterExec("/example-exec:run", script, NewExecutor(time.Minute, 1), Async()); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteRESTCONF(app, rc); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := doRequest(t, app, "POST", "/restconf/operations/example-exec:run", `{"example-exec:input":{"delay":30}}`)
+	if resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("POST run = %d, want %d", resp.StatusCode, fiber.StatusAccepted)
+	}
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/restconf/data/open-restconf-jobs:jobs/job=1"; location.Path != want {
+		t.Fatalf("Location = %s, want %s", location.Path, want)
+	}
+
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if b, err := os.ReadFile(pidfile); err == nil {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		}
+	}
+	if pid == 0 {
+		t.Fatal("the executable not started")
+	}
+	t.Cleanup(func() { syscall.Kill(-pid, syscall.SIGKILL) })
+	if resp := doRequest(t, app, "POST", location.Path+"/cancel", ""); resp.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("POST cancel = %d, want %d", resp.StatusCode, fiber.StatusNoContent)
+	}
+
+	var status string
+	for i := 0; i < 200 && status != "canceled"; i++ {
+		time.Sleep(50 * time.Millisecond)
+		(&Datastore{rc: rc}).Read(func(root yangtree.DataNode) error {
+			if found, err := yangtree.Find(root, "jobs/job[id=1]/status"); err == nil && len(found) == 1 {
+				status = found[0].ValueString()
+			}
+			return nil
+		})
+	}
+	if status != "canceled" {
+		t.Fatalf("status = %v, want canceled", status)
+	}
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("the executable (pid %d) still running: %v", pid, err)
+	}
+	if resp := doRequest(t, app, "POST", location.Path+"/cancel", ""); resp.StatusCode != fiber.StatusConflict {
+		t.Errorf("POST cancel of the canceled job = %d, want %d", resp.StatusCode, fiber.StatusConflict)
+	}
+}
+
+func Test_ExecExitStatus(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-exec.yang"}, *dir, *excludes)
+	schema, err := findSchema(rc.schemaOperations, "/example-exec:run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "warning on success", script: "echo warning >&2\nexit 0\n"},
+		{name: "failure", script: "echo disk full >&2\nexit 3\n", wantErr: "disk full"},
+		{name: "background child", script: "sleep 30 &\nexit 0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			pgidfile := filepath.Join(tmp, "pgid")
+			script := filepath.Join(tmp, "run.sh")
+			if err := os.WriteFile(script, []byte("#!/bin/sh\necho $$ > "+pgidfile+"\n"+tt.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			// The executable is the leader of its process group, so the
+			// child processes left behind are killed with the group.
+			t.Cleanup(func() {
+				if b, err := os.ReadFile(pgidfile); err == nil {
+					if pgid, _ := strconv.Atoi(strings.TrimSpace(string(b))); pgid > 0 {
+						syscall.Kill(-pgid, syscall.SIGKILL)
+					}
+				}
+			})
+			handler := NewExecutor(10*time.Second, 1).handler(rc, []string{script})
+			start := time.Now()
+			_, err := handler(&RPCRequest{Path: "/restconf/operations/example-exec:run", Schema: schema})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("handler() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(errorMessage(err), tt.wantErr)) {
+				t.Errorf("handler() error = %v, want %q", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("handler() took %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// maxFinishedJobs is the number of the finished jobs kept in the datastore.
+const maxFinishedJobs = 100
+
+// Job is the rpc or action running asynchronously. The state of the job is
+// kept in the job list (open-restconf-jobs:jobs/job) of the datastore.
+type Job struct {
+	ID     string
+	rc     *RESTCtrl
+	xpath  string // the data path of the job entry
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Context() returns the context of the job canceled by the cancel action.
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// SetProgress() updates the progress (0..100 percent) of the job.
+func (j *Job) SetProgress(percent int) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	j.rc.Lock()
+	defer j.rc.Unlock()
+	j.update(map[string]string{"progress": strconv.Itoa(percent)})
+}
+
+// update() sets the leaf values of the job entry in the datastore.
+// It must be called with the datastore lock.
+func (j *Job) update(values map[string]string) {
+	for name, value := range values {
+		if err := yangtree.SetValue(j.rc.DataRoot, j.xpath+"/"+name, nil, value); err != nil {
+			log.Printf("restconf: unable to update job %s: %v", j.ID, err)
+		}
+	}
+	j.rc.revisions.Touch(j.xpath)
+}
+
+// run() runs the handler of the job and updates the result of the job.
+func (j *Job) run(handler RPCHandler, req *RPCRequest) {
+	defer j.cancel()
+	output, err := j.rc.callHandler(handler, req)
+	values := map[string]string{"end-time": time.Now().UTC().Format(time.RFC3339)}
+	switch {
+	case j.ctx.Err() == context.Canceled:
+		values["status"] = "canceled"
+	case err != nil:
+		values["status"] = "failed"
+		values["error-message"] = errorMessage(err)
+	default:
+		values["status"] = "completed"
+		values["progress"] = "100"
+		if output != nil {
+			b, err := yangtree.MarshalJSON(output, yangtree.RepresentItself{})
+			if err != nil {
+				values["status"] = "failed"
+				values["error-message"] = err.Error()
+				break
+			}
+			values["output"] = string(b)
+		}
+	}
+	j.rc.Lock()
+	defer j.rc.Unlock()
+	j.update(values)
+	j.rc.jobs.finish(j)
+}
+
+// errorMessage() returns the error-message of the error.
+func errorMessage(err error) string {
+	if re, ok := err.(*RespError); ok && len(re.Errors) > 0 {
+		if msg := re.Errors[0].GetValueString("error-message"); msg != "" {
+			return msg
+		}
+	}
+	return err.Error()
+}
+
+// Jobs is the registry of the jobs.
+type Jobs struct {
+	sync.Mutex
+	next     uint64
+	running  map[string]*Job
+	finished []*Job // in the finished order
+}
+
+// NewJobs() returns the job registry.
+func NewJobs() *Jobs {
+	return &Jobs{running: map[string]*Job{}}
+}
+
+// finish() moves the job to the finished jobs and deletes the oldest
+// finished jobs from the datastore. It must be called with the datastore lock.
+func (jobs *Jobs) finish(j *Job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	delete(jobs.running, j.ID)
+	jobs.finished = append(jobs.finished, j)
+	for len(jobs.finished) > maxFinishedJobs {
+		old := jobs.finished[0]
+		jobs.finished = jobs.finished[1:]
+		if found, err := yangtree.Find(j.rc.DataRoot, old.xpath); err == nil && len(found) > 0 {
+			found[0].Remove()
+		}
+		j.rc.revisions.Touch(old.xpath)
+	}
+}
+
+// StartJob() starts the handler of the rpc or action as a job and responds
+// "202 Accepted" with the URI of the job in the Location header.
+func (rc *RESTCtrl) StartJob(c *fiber.Ctx, handler RPCHandler, req *RPCRequest) error {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.jobs.Lock()
+	rc.jobs.next++
+	j := &Job{ID: strconv.FormatUint(rc.jobs.next, 10), rc: rc}
+	rc.jobs.running[j.ID] = j
+	rc.jobs.Unlock()
+
+	j.xpath = fmt.Sprintf("jobs/job[id=%s]", j.ID)
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	values := map[string]string{
+		"status":     "running",
+		"progress":   "0",
+		"start-time": time.Now().UTC().Format(time.RFC3339),
+	}
+	if req.Target != nil {
+		values["operation"] = Schema2RPath(rc.schemaData, req.Schema)
+		if found, err := yangtree.Find(rc.DataRoot, req.TargetPath); err == nil && len(found) == 1 {
+			values["target"] = "/restconf/data" + Node2RPath(found[0])
+		}
+	} else {
+		values["operation"] = Schema2RPath(rc.schemaOperations, req.Schema)
+	}
+	if req.User != "" {
+		values["user"] = req.User
+	}
+	j.update(values)
+	found, err := yangtree.Find(rc.DataRoot, j.xpath)
+	if err != nil || len(found) != 1 {
+		j.cancel()
+		rc.jobs.Lock()
+		delete(rc.jobs.running, j.ID)
+		rc.jobs.Unlock()
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), fmt.Sprintf("unable to create job %s", j.ID))
+	}
+
+	// The request context is not available after the response.
+	req.Ctx = nil
+	req.Path = string([]byte(req.Path))
+	req.User = string([]byte(req.User))
+	req.Job = j
+	go j.run(handler, req)
+
+	c.Location(c.BaseURL() + "/restconf/data" + Node2RPath(found[0]))
+	return rc.Response(c, &RespData{Status: fiber.StatusAccepted})
+}
+
+// CancelJob() is the handler of the cancel action of the job.
+func (rc *RESTCtrl) CancelJob(req *RPCRequest) (yangtree.DataNode, error) {
+	var id string
+	req.Data.Read(func(root yangtree.DataNode) error {
+		if found, err := yangtree.Find(root, req.TargetPath); err == nil && len(found) == 1 {
+			id = found[0].GetValueString("id")
+		}
+		return nil
+	})
+	rc.jobs.Lock()
+	j, ok := rc.jobs.running[id]
+	rc.jobs.Unlock()
+	if !ok {
+		return nil, NewError(rc, ETagResourceDenied.Status(), ETypeApplication,
+			ETagResourceDenied, req.Path, fmt.Sprintf("job %s is not running", id))
+	}
+	j.cancel()
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_JobRun(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	schema, err := findSchema(rc.schemaData, "/open-restconf-jobs:jobs/job/cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		handler RPCHandler
+		cancel  bool
+		want    string
+	}{
+		{name: "completed", handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+			req.Job.SetProgress(50)
+			return nil, nil
+		}, want: "completed"},
+		{name: "failed", handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+			return nil, fmt.Errorf("failure")
+		}, want: "failed"},
+		{name: "canceled", handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+			<-req.Job.Context().Done()
+			return nil, req.Job.Context().Err()
+		}, cancel: true, want: "canceled"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &Job{ID: fmt.Sprint(i), rc: rc, xpath: fmt.Sprintf("jobs/job[id=%d]", i)}
+			j.ctx, j.cancel = context.WithCancel(context.Background())
+			rc.jobs.running[j.ID] = j
+			if tt.cancel {
+				j.cancel()
+			}
+			j.run(tt.handler, &RPCRequest{Path: "/restconf/data", Schema: schema, Job: j})
+			found, err := yangtree.Find(rc.DataRoot, j.xpath+"/status")
+			if err != nil || len(found) != 1 {
+				t.Fatalf("unable to find the job status: %v", err)
+			}
+			if got := found[0].ValueString(); got != tt.want {
+				t.Errorf("status = %v, want %v", got, tt.want)
+			}
+			if _, ok := rc.jobs.running[j.ID]; ok {
+				t.Errorf("job %s is still running", j.ID)
+			}
+		})
+	}
+}
+
+func Test_CancelJob(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	tests := []struct {
+		name    string
+		running bool
+		want    int // 0 if canceled
+	}{
+		{name: "running", running: true},
+		{name: "finished", want: fiber.StatusConflict},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &Job{ID: fmt.Sprint(i), rc: rc, xpath: fmt.Sprintf("jobs/job[id=%d]", i)}
+			j.ctx, j.cancel = context.WithCancel(context.Background())
+			defer j.cancel()
+			status := "completed"
+			if tt.running {
+				status = "running"
+				rc.jobs.running[j.ID] = j
+				defer delete(rc.jobs.running, j.ID)
+			}
+			if err := yangtree.SetValue(rc.DataRoot, j.xpath+"/status", nil, status); err != nil {
+				t.Fatal(err)
+			}
+			_, err := rc.CancelJob(&RPCRequest{Path: "/restconf/data" + j.xpath,
+				Data: &Datastore{rc: rc}, TargetPath: j.xpath})
+			if tt.want == 0 {
+				if err != nil {
+					t.Fatalf("CancelJob() error = %v", err)
+				}
+				if j.ctx.Err() != context.Canceled {
+					t.Errorf("job %s not canceled", j.ID)
+				}
+				return
+			}
+			re, ok := err.(*RespError)
+			if !ok {
+				t.Fatalf("CancelJob() error = %v, want *RespError", err)
+			}
+			if re.Code != tt.want {
+				t.Errorf("CancelJob() code = %d, want %d", re.Code, tt.want)
+			}
+			if tag := re.Errors[0].GetValueString("error-tag"); tag != ETagResourceDenied.String() {
+				t.Errorf("CancelJob() error-tag = %s, want %s", tag, ETagResourceDenied)
+			}
+		})
+	}
+}
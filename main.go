@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofiber/fiber"
 	"github.com/gofiber/fiber/middleware/logger"
@@ -19,14 +20,20 @@ import (
 
 type RESTCtrl struct {
 	sync.RWMutex
-	DataRoot         yangtree.DataNode // /restconf/data
-	schemaError      *yangtree.SchemaNode
-	schemaErrors     *yangtree.SchemaNode
-	schemaRESTCONF   *yangtree.SchemaNode
-	schemaData       *yangtree.SchemaNode
-	schemaOperations *yangtree.SchemaNode
-	rootSchema       *yangtree.SchemaNode
-	yangLibVersion   string
+	DataRoot             yangtree.DataNode // /restconf/data
+	AllowDatastoreDelete bool              // allow DELETE on the datastore resource
+	schemaError          *yangtree.SchemaNode
+	schemaErrors         *yangtree.SchemaNode
+	schemaRESTCONF       *yangtree.SchemaNode
+	schemaData           *yangtree.SchemaNode
+	schemaOperations     *yangtree.SchemaNode
+	schemaPatchStatus    *yangtree.SchemaNode
+	rootSchema           *yangtree.SchemaNode
+	yangLibVersion       string
+	revisions            *Revisions // entity-tags and timestamps of data resources
+	handlers             map[*yangtree.SchemaNode]*rpcEntry
+	jobs                 *Jobs
+	streams              map[string]*Stream // event streams by name
 }
 
 var (
@@ -37,10 +44,30 @@ var (
 	yangfiles     = pflag.StringArrayP("files", "f", []string{}, "yang files to load")
 	dir           = pflag.StringArrayP("dir", "d", []string{}, "directories to search yang includes and imports")
 	excludes      = pflag.StringArrayP("exclude", "e", []string{}, "yang modules to be excluded from path generation")
+	allowDelete   = pflag.Bool("allow-datastore-delete", false, "allow DELETE on the datastore resource (/restconf/data)")
+	execs         = pflag.StringArray("exec", []string{}, "run the rpc or action by the executable ([async:]schema-path=command)")
+	execTimeout   = pflag.Duration("exec-timeout", 30*time.Second, "timeout of the rpc or action executable")
+	execMax       = pflag.Int("exec-max", 4, "maximum number of the rpc or action executables running concurrently")
+	replaySize    = pflag.Int("replay-size", 1000, "number of the notifications kept for the replay of the event stream")
+	users         = pflag.StringArray("user", []string{}, "user of the HTTP basic authentication (name:password)")
+
+	// RESTCONF capabilities (RFC8040 9.1.1) advertised in restconf-state.
+	capabilities = []string{
+		"urn:ietf:params:restconf:capability:defaults:1.0?basic-mode=explicit",
+		"urn:ietf:params:restconf:capability:depth:1.0",
+		"urn:ietf:params:restconf:capability:fields:1.0",
+		"urn:ietf:params:restconf:capability:filter:1.0",
+		"urn:ietf:params:restconf:capability:replay:1.0",
+		"urn:ietf:params:restconf:capability:with-defaults:1.0",
+		"urn:ietf:params:restconf:capability:yang-patch:1.0",
+	}
 
 	restfiles = []string{
 		"modules/ietf-yang-library@2016-06-21.yang",
 		"modules/ietf-restconf@2017-01-26.yang",
+		"modules/ietf-yang-patch@2017-02-22.yang",
+		"modules/ietf-restconf-monitoring@2017-01-26.yang",
+		"modules/open-restconf-jobs@2026-10-18.yang",
 		// "modules/ietf-interfaces@2018-02-20.yang",
 		// "modules/iana-if-type@2017-01-19.yang",
 
@@ -53,7 +80,12 @@ var (
 
 func loadSchema(file, dir, excludes []string) *RESTCtrl {
 	var err error
-	rc := &RESTCtrl{}
+	rc := &RESTCtrl{
+		revisions: NewRevisions(),
+		handlers:  map[*yangtree.SchemaNode]*rpcEntry{},
+		jobs:      NewJobs(),
+		streams:   map[string]*Stream{},
+	}
 	file = append(file, restfiles...)
 	rc.rootSchema, err = yangtree.Load(file, dir, excludes, yangtree.YANGTreeOption{YANGLibrary2016: true})
 	if err != nil {
@@ -79,6 +111,16 @@ func loadSchema(file, dir, excludes []string) *RESTCtrl {
 		log.Fatalf("restconf: unable to load yang-errors/errors/error schema")
 	}
 
+	// load yang-patch-status.
+	yangpatchSchema := rc.rootSchema.ExtSchema["yang-patch-status"]
+	if yangpatchSchema == nil {
+		log.Fatalf("restconf: unable to load yang-patch-status schema")
+	}
+	rc.schemaPatchStatus = yangpatchSchema.GetSchema("yang-patch-status")
+	if rc.schemaPatchStatus == nil {
+		log.Fatalf("restconf: unable to load yang-patch-status/yang-patch-status schema")
+	}
+
 	// load restconf.top.
 	yangapiSchema := rc.rootSchema.ExtSchema["yang-api"]
 	if yangapiSchema == nil {
@@ -108,6 +150,9 @@ func loadSchema(file, dir, excludes []string) *RESTCtrl {
 			rc.schemaData.Append(true, rc.rootSchema.Children[i])
 		}
 	}
+	if err := rc.RegisterAction("/open-restconf-jobs:jobs/job/cancel", rc.CancelJob); err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	return rc
 }
@@ -138,6 +183,13 @@ func main() {
 		log.Fatalf("restconf: unable to add the yanglibrary: %v", err)
 	}
 
+	// advertise restconf capabilities.
+	for i := range capabilities {
+		if err := yangtree.SetValue(dataroot, "restconf-state/capabilities/capability", nil, capabilities[i]); err != nil {
+			log.Fatalf("restconf: unable to add the capability: %v", err)
+		}
+	}
+
 	// load startup data.
 	if *startupFile != "" {
 		var file *os.File
@@ -178,7 +230,37 @@ func main() {
 		Format: "[${time}] ${status} - ${latency} ${method} ${path}\n",
 	}))
 	app.Use(requestid.New()) // add requestid
+	if len(*users) > 0 {
+		auth, err := rc.BasicAuth(*users)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		app.Use(auth) // set the authenticated user
+	}
 	rc.DataRoot = dataroot
+	rc.AllowDatastoreDelete = *allowDelete
+	// add the default event stream.
+	if _, err := rc.AddStream(DefaultStream, "default NETCONF event stream", *replaySize); err != nil {
+		log.Fatalf("%v", err)
+	}
+	// register the rpc and action executables.
+	executor := NewExecutor(*execTimeout, *execMax)
+	for i := range *execs {
+		// The async: prefix runs the executable asynchronously as a job.
+		var opts []RPCOption
+		spec := (*execs)[i]
+		if strings.HasPrefix(spec, "async:") {
+			spec = strings.TrimPrefix(spec, "async:")
+			opts = append(opts, Async())
+		}
+		mapping := strings.SplitN(spec, "=", 2)
+		if len(mapping) != 2 {
+			log.Fatalf("restconf: invalid exec %q ([async:]schema-path=command)", (*execs)[i])
+		}
+		if err := rc.RegisterExec(mapping[0], mapping[1], executor, opts...); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 	// register restconf host-meta info.
 	if err := InstallRouteHostMeta(app, rc); err != nil {
 		log.Fatalf("restconf: %v", err)
@@ -186,6 +268,9 @@ func main() {
 	if err := InstallRouteRESTCONF(app, rc); err != nil {
 		log.Fatalf("restconf: %v", err)
 	}
+	if err := InstallRouteStreams(app, rc); err != nil {
+		log.Fatalf("restconf: %v", err)
+	}
 	if err := InstallRouteSchemaPath(app, rc); err != nil {
 		log.Fatalf("restconf: %v", err)
 	}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// RFC8040 4.8. Query Parameters
+
+// QueryParam is the query parameters of the GET request.
+type QueryParam struct {
+	Content string // "content" parameter: config, nonconfig or all
+	Depth   int    // "depth" parameter: 1..65535 or 0 for unbounded
+	Fields  Fields // "fields" parameter: nil if not present
+	// "with-defaults" parameter: report-all, trim, explicit or report-all-tagged
+	WithDefaults string
+}
+
+// ParseQuery() parses and validates the query parameters of the GET request
+// for the target resource of the schema.
+func (rc *RESTCtrl) ParseQuery(c *fiber.Ctx, schema *yangtree.SchemaNode) (*QueryParam, error) {
+	q := &QueryParam{
+		Content: c.Query("content", "all"),
+	}
+	switch q.Content {
+	case "config", "nonconfig", "all":
+	default:
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), fmt.Sprintf("invalid content parameter %q", q.Content))
+	}
+	if depth := c.Query("depth", "unbounded"); depth != "unbounded" {
+		d, err := strconv.Atoi(depth)
+		if err != nil || d < 1 || d > 65535 {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid depth parameter %q", depth))
+		}
+		q.Depth = d
+	}
+	if fields := c.Query("fields"); fields != "" {
+		f, err := ParseFields(fields)
+		if err != nil {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid fields parameter: %v", err))
+		}
+		if err := f.validate(schema); err != nil {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid fields parameter: %v", err))
+		}
+		q.Fields = f
+	}
+	q.WithDefaults = c.Query("with-defaults", "explicit")
+	switch q.WithDefaults {
+	case "report-all", "trim", "explicit", "report-all-tagged":
+	default:
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), fmt.Sprintf("invalid with-defaults parameter %q", q.WithDefaults))
+	}
+	// The default nodes are tagged only in XML and JSON. (RFC6243 6.)
+	if q.WithDefaults == "report-all-tagged" && strings.HasSuffix(acceptedType(c), "yaml") {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "with-defaults=report-all-tagged is not supported in YAML")
+	}
+	return q, nil
+}
+
+// InsertOption() returns the edit option to insert the new data node of the
+// schema according to the "insert" and "point" query parameters (RFC8040
+// 4.8.5, 4.8.6) of the POST or PUT request. It returns nil if not specified.
+func (rc *RESTCtrl) InsertOption(c *fiber.Ctx, schema *yangtree.SchemaNode, ppath string) (*yangtree.EditOption, error) {
+	insert := c.Query("insert")
+	point := c.Query("point")
+	if insert == "" {
+		if point != "" {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "point parameter must be used with insert parameter")
+		}
+		return nil, nil
+	}
+	if !isOrderedByUser(schema) {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), fmt.Sprintf("insert parameter is not allowed for %s not ordered-by user", schema.Name))
+	}
+	if (insert == "first" || insert == "last") && point != "" {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "point parameter is only allowed for insert=before or insert=after")
+	}
+	point = strings.TrimPrefix(point, "/restconf/data")
+	p, err := rc.resolvePoint(rc.DataRoot, schema, ppath, c.Path(), insert, point)
+	if err != nil {
+		return nil, err
+	}
+	return &yangtree.EditOption{InsertOption: insertOption(insert, p)}, nil
+}
+
+// Apply() returns the data nodes retrieved according to the query parameters.
+// The data nodes are copied if they need to be modified by the query parameters.
+func (q *QueryParam) Apply(nodes []yangtree.DataNode) []yangtree.DataNode {
+	if q.Content == "all" && q.Depth == 0 && q.Fields == nil && q.WithDefaults == "explicit" {
+		return nodes
+	}
+	result := make([]yangtree.DataNode, 0, len(nodes))
+	for i := range nodes {
+		node := yangtree.Clone(nodes[i])
+		if q.Content != "all" && !filterContent(node, q.Content == "config") {
+			continue
+		}
+		switch q.WithDefaults {
+		case "report-all", "report-all-tagged":
+			addDefaults(node)
+		case "trim":
+			trimDefaults(node)
+		}
+		if q.Fields != nil {
+			selectFields(node, q.Fields)
+		}
+		if q.Depth > 0 {
+			trimDepth(node, q.Depth)
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// trimDepth() deletes the descendants of the node deeper than the depth.
+// The requested data node has a depth level of "1". The key leafs of the
+// list entry are not deleted to identify the list entry.
+func trimDepth(node yangtree.DataNode, depth int) {
+	if !node.IsBranchNode() {
+		return
+	}
+	for _, child := range copyNodes(node.Children()) {
+		switch {
+		case child.Schema().IsKey:
+		case depth <= 1:
+			node.Delete(child)
+		default:
+			trimDepth(child, depth-1)
+		}
+	}
+}
+
+// filterContent() deletes the descendants of the node that are not matched
+// to the content parameter. It returns true if the node needs to be kept
+// because it or one of its descendants is matched.
+func filterContent(node yangtree.DataNode, config bool) bool {
+	if node.IsStateNode() {
+		return !config // all descendants of the state node are state nodes.
+	}
+	if !node.IsBranchNode() {
+		return config
+	}
+	matched := config
+	for _, child := range copyNodes(node.Children()) {
+		if child.Schema().IsKey {
+			continue // keys are kept to identify the list entry.
+		}
+		if filterContent(child, config) {
+			matched = true
+		} else {
+			node.Delete(child)
+		}
+	}
+	return matched
+}
+
+// Fields is the parsed "fields" parameter (RFC8040 4.8.3) that selects the
+// child nodes to be retrieved. A nil Fields of a child selects the child
+// and all of its descendants.
+//
+//	fields-expr = path "(" fields-expr ")" / path ";" fields-expr / path
+//	path = api-identifier [ "/" path ]
+type Fields map[string]Fields
+
+// ParseFields() parses the fields expression.
+func ParseFields(expr string) (Fields, error) {
+	f := Fields{}
+	pos, err := f.parse(expr, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos < len(expr) {
+		return nil, fmt.Errorf("unexpected %q at %d", expr[pos], pos)
+	}
+	return f, nil
+}
+
+// parse() parses the fields-expr from the pos of the expr and returns the
+// position where the parsing is stopped (the end of the expr or ')').
+func (f Fields) parse(expr string, pos int) (int, error) {
+	for {
+		cur := f
+		for {
+			end := strings.IndexAny(expr[pos:], "/;()")
+			if end < 0 {
+				end = len(expr)
+			} else {
+				end += pos
+			}
+			id := expr[pos:end]
+			if id == "" {
+				return pos, fmt.Errorf("empty identifier at %d", pos)
+			}
+			pos = end
+			if pos < len(expr) && expr[pos] == '/' {
+				pos++
+				cur = cur.sub(id)
+				continue
+			}
+			if pos < len(expr) && expr[pos] == '(' {
+				var err error
+				if pos, err = cur.sub(id).parse(expr, pos+1); err != nil {
+					return pos, err
+				}
+				if pos >= len(expr) || expr[pos] != ')' {
+					return pos, fmt.Errorf("missing ')'")
+				}
+				pos++
+			} else {
+				cur[id] = nil // select all descendants
+			}
+			break
+		}
+		if pos >= len(expr) || expr[pos] == ')' {
+			return pos, nil
+		}
+		if expr[pos] != ';' {
+			return pos, fmt.Errorf("unexpected %q at %d", expr[pos], pos)
+		}
+		pos++
+	}
+}
+
+// sub() returns the Fields of the child id to select the descendants.
+// If the child has been selected with all descendants, a throwaway Fields
+// is returned to keep the selection.
+func (f Fields) sub(id string) Fields {
+	if sub, ok := f[id]; ok {
+		if sub == nil {
+			return Fields{}
+		}
+		return sub
+	}
+	sub := Fields{}
+	f[id] = sub
+	return sub
+}
+
+// validate() checks the identifiers of the Fields exist in the schema
+// and removes the module-name of the identifiers.
+func (f Fields) validate(schema *yangtree.SchemaNode) error {
+	// The prefixed identifiers are renamed after the iteration
+	// not to visit the renamed entries again.
+	renamed := map[string]string{}
+	for id, sub := range f {
+		name, module := id, ""
+		if i := strings.Index(id, ":"); i >= 0 {
+			name, module = id[i+1:], id[:i]
+		}
+		cschema := schema.GetSchema(name)
+		if cschema == nil {
+			return fmt.Errorf("unable to find %s in %s", id, schema.Name)
+		}
+		if module != "" && module != moduleName(cschema) {
+			return fmt.Errorf("%s is not defined in module %s", name, module)
+		}
+		if sub != nil {
+			if err := sub.validate(cschema); err != nil {
+				return err
+			}
+		}
+		if name != id {
+			renamed[id] = name
+		}
+	}
+	for id, name := range renamed {
+		f[name] = f[id]
+		delete(f, id)
+	}
+	return nil
+}
+
+// selectFields() deletes the child nodes of the node not selected by the Fields.
+func selectFields(node yangtree.DataNode, f Fields) {
+	if !node.IsBranchNode() {
+		return
+	}
+	for _, child := range copyNodes(node.Children()) {
+		if child.Schema().IsKey {
+			continue // keys are kept to identify the list entry.
+		}
+		sub, ok := f[child.Name()]
+		switch {
+		case !ok:
+			node.Delete(child)
+		case sub != nil:
+			selectFields(child, sub)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_ParseFields(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Fields
+		wantErr bool
+	}{
+		{expr: "name", want: Fields{"name": nil}},
+		{expr: "name;album", want: Fields{"name": nil, "album": nil}},
+		{expr: "album/year", want: Fields{"album": Fields{"year": nil}}},
+		{expr: "album(name;year)", want: Fields{"album": Fields{"name": nil, "year": nil}}},
+		{expr: "name;album(name;song/name)",
+			want: Fields{"name": nil, "album": Fields{"name": nil, "song": Fields{"name": nil}}}},
+		{expr: "album;album/year", want: Fields{"album": nil}},
+		{expr: "album/year;album/name", want: Fields{"album": Fields{"year": nil, "name": nil}}},
+		{expr: "example-jukebox:library/artist", want: Fields{"example-jukebox:library": Fields{"artist": nil}}},
+		{expr: "", wantErr: true},
+		{expr: "album(name", wantErr: true},
+		{expr: "album)", wantErr: true},
+		{expr: "album//name", wantErr: true},
+		{expr: "name;", wantErr: true},
+		{expr: "album()", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseFields(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFields() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseQueryWithDefaults(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-defaults.yang"}, *dir, *excludes)
+	schema := rc.schemaData.GetSchema("system")
+	if schema == nil {
+		t.Fatal("system schema not found")
+	}
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+		status int
+	}{
+		{query: "", want: "explicit", status: fiber.StatusOK},
+		{query: "with-defaults=explicit", want: "explicit", status: fiber.StatusOK},
+		{query: "with-defaults=trim", want: "trim", status: fiber.StatusOK},
+		{query: "with-defaults=report-all", want: "report-all", status: fiber.StatusOK},
+		{query: "with-defaults=report-all-tagged", accept: "application/yang-data+json",
+			want: "report-all-tagged", status: fiber.StatusOK},
+		{query: "with-defaults=report-all-tagged", accept: "application/yang-data+xml",
+			want: "report-all-tagged", status: fiber.StatusOK},
+		{query: "with-defaults=report-all-tagged", accept: "application/yang-data+yaml",
+			status: fiber.StatusBadRequest},
+		{query: "with-defaults=report-all", accept: "application/yang-data+yaml",
+			want: "report-all", status: fiber.StatusOK},
+		{query: "with-defaults=all", status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+" "+tt.accept, func(t *testing.T) {
+			var got string
+			app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+			app.Get("/", func(c *fiber.Ctx) error {
+				q, err := rc.ParseQuery(c, schema)
+				if err != nil {
+					return err
+				}
+				got = q.WithDefaults
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set(fiber.HeaderAccept, tt.accept)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("ParseQuery() status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got != tt.want {
+				t.Errorf("ParseQuery() with-defaults = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ApplyWithDefaults(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-defaults.yang"}, *dir, *excludes)
+	tests := []struct {
+		mode    string
+		data    string
+		present []string
+		absent  []string
+	}{
+		{
+			mode:    "explicit",
+			data:    `{"example-defaults:system":{"hostname":"r1","mtu":1500}}`,
+			present: []string{"hostname", "mtu"},
+			absent:  []string{"logging", "dhcp-timeout"},
+		},
+		{
+			mode:    "trim",
+			data:    `{"example-defaults:system":{"hostname":"r1","mtu":1500,"logging":{"level":"info"}}}`,
+			present: []string{"hostname", "logging"},
+			absent:  []string{"mtu", "logging/level"},
+		},
+		{
+			mode:    "report-all",
+			data:    `{"example-defaults:system":{"hostname":"r1"}}`,
+			present: []string{"hostname", "mtu", "logging/level", "dhcp-timeout"},
+			absent:  []string{"ntp", "ip", "prefix-length"},
+		},
+		{
+			mode:    "report-all",
+			data:    `{"example-defaults:system":{"ip":"192.0.2.1","ntp":{}}}`,
+			present: []string{"mtu", "logging/level", "ip", "prefix-length", "ntp/server"},
+			absent:  []string{"dhcp-timeout"},
+		},
+		{
+			mode:    "report-all-tagged",
+			data:    `{"example-defaults:system":{"hostname":"r1","mtu":1500}}`,
+			present: []string{"hostname", "mtu", "logging/level", "dhcp-timeout"},
+			absent:  []string{"ntp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			root, err := yangtree.New(rc.schemaData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := yangtree.UnmarshalJSON(root, []byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			found, err := yangtree.Find(root, "system")
+			if err != nil || len(found) != 1 {
+				t.Fatalf("system not found: %v", err)
+			}
+			before, err := yangtree.MarshalJSON(found[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := &QueryParam{Content: "all", WithDefaults: tt.mode}
+			result := q.Apply(found)
+			if len(result) != 1 {
+				t.Fatalf("Apply() = %d nodes, want 1", len(result))
+			}
+			for _, p := range tt.present {
+				if got, _ := yangtree.Find(result[0], p); len(got) == 0 {
+					t.Errorf("Apply() %s: %s not found", tt.mode, p)
+				}
+			}
+			for _, p := range tt.absent {
+				if got, _ := yangtree.Find(result[0], p); len(got) != 0 {
+					t.Errorf("Apply() %s: %s found", tt.mode, p)
+				}
+			}
+			after, err := yangtree.MarshalJSON(found[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(before) != string(after) {
+				t.Errorf("Apply() modified the datastore: %s, want %s", after, before)
+			}
+		})
+	}
+}
+
+func Test_ParseQueryFields(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	schema := rc.schemaData.GetSchema("jukebox")
+	if schema == nil {
+		t.Fatal("jukebox schema not found")
+	}
+	tests := []struct {
+		fields string
+		want   Fields
+		status int
+	}{
+		{fields: "library/artist(name;album/name)",
+			want:   Fields{"library": Fields{"artist": Fields{"name": nil, "album": Fields{"name": nil}}}},
+			status: fiber.StatusOK},
+		{fields: "example-jukebox:library/example-jukebox:artist(example-jukebox:name;example-jukebox:album)",
+			want:   Fields{"library": Fields{"artist": Fields{"name": nil, "album": nil}}},
+			status: fiber.StatusOK},
+		{fields: "library/artist(name;album/unknown)", status: fiber.StatusBadRequest},
+		{fields: "library/unknown(name)", status: fiber.StatusBadRequest},
+		{fields: "library/artist(name", status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			var got Fields
+			app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+			app.Get("/", func(c *fiber.Ctx) error {
+				q, err := rc.ParseQuery(c, schema)
+				if err != nil {
+					return err
+				}
+				got = q.Fields
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/?fields="+url.QueryEscape(tt.fields), nil)
+			req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("ParseQuery() status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != fiber.StatusOK {
+				b, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(b), ETagInvalidValue.String()) {
+					t.Errorf("ParseQuery() error = %s, want %s", b, ETagInvalidValue)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQuery() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FieldsValidate(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	schema, err := findSchema(rc.schemaData, "/example-jukebox:jukebox/library/artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All prefixed identifiers must be renamed whatever the iteration order is.
+	for i := 0; i < 20; i++ {
+		f := Fields{"example-jukebox:name": nil, "example-jukebox:album": Fields{"example-jukebox:name": nil, "year": nil}}
+		if err := f.validate(schema); err != nil {
+			t.Fatal(err)
+		}
+		want := Fields{"name": nil, "album": Fields{"name": nil, "year": nil}}
+		if !reflect.DeepEqual(f, want) {
+			t.Fatalf("validate() = %v, want %v", f, want)
+		}
+	}
+	for _, f := range []Fields{
+		{"unknown": nil},
+		{"bogus-module:name": nil},
+		{"album": Fields{"bogus-module:year": nil}},
+	} {
+		if err := f.validate(schema); err == nil {
+			t.Errorf("validate(%v) succeeded, want error", f)
+		}
+	}
+}
+
+// rfcJukebox is the jukebox data of the examples in RFC8040 B.3.
+const rfcJukebox = `{"example-jukebox:jukebox":{
+	"library":{
+		"artist":[{"name":"Foo Fighters","album":[{"name":"Wasting Light",
+			"genre":"example-jukebox:alternative","year":2011,
+			"song":[{"name":"Wasting Light","location":"/media/foo/a7/wasting-light.mp3",
+				"format":"MP3","length":286}]}]}],
+		"artist-count":42,"album-count":59,"song-count":374},
+	"playlist":[{"name":"Foo-One","description":"example playlist 1",
+		"song":[{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='Foo Fighters']"}]}],
+	"player":{"gap":"0.5"}}}`
+
+// applyJukebox() applies the query parameters to the jukebox of the data and
+// returns the result. The datastore must not be modified by the query.
+func applyJukebox(t *testing.T, data string, q *QueryParam) yangtree.DataNode {
+	t.Helper()
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yangtree.UnmarshalJSON(root, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	found, err := yangtree.Find(root, "jukebox")
+	if err != nil || len(found) != 1 {
+		t.Fatalf("jukebox not found: %v", err)
+	}
+	before, err := yangtree.MarshalJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := q.Apply(found)
+	after, err := yangtree.MarshalJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("Apply() modified the datastore: %s, want %s", after, before)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Apply() = %d nodes, want 1", len(result))
+	}
+	return result[0]
+}
+
+func Test_ApplyContent(t *testing.T) {
+	tests := []struct {
+		content string
+		present []string
+		absent  []string
+	}{
+		{
+			// RFC8040 B.3.1: content=config
+			content: "config",
+			present: []string{"library/artist[name='Foo Fighters']/album[name='Wasting Light']/song[name='Wasting Light']/length",
+				"playlist[name=Foo-One]/song[index=1]/id", "player/gap"},
+			absent: []string{"library/artist-count", "library/album-count", "library/song-count"},
+		},
+		{
+			// RFC8040 B.3.1: content=nonconfig
+			content: "nonconfig",
+			present: []string{"library/artist-count", "library/album-count", "library/song-count"},
+			absent:  []string{"library/artist", "playlist", "player"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			node := applyJukebox(t, rfcJukebox, &QueryParam{Content: tt.content, WithDefaults: "explicit"})
+			for _, p := range tt.present {
+				if got, _ := yangtree.Find(node, p); len(got) == 0 {
+					t.Errorf("Apply() content=%s: %s not found", tt.content, p)
+				}
+			}
+			for _, p := range tt.absent {
+				if got, _ := yangtree.Find(node, p); len(got) != 0 {
+					t.Errorf("Apply() content=%s: %s found", tt.content, p)
+				}
+			}
+		})
+	}
+}
+
+func Test_ApplyDepth(t *testing.T) {
+	tests := []struct {
+		depth   int
+		present []string
+		absent  []string
+	}{
+		{
+			// RFC8040 B.3.2: depth=1 returns the empty jukebox.
+			depth:  1,
+			absent: []string{"library", "playlist", "player"},
+		},
+		{
+			// RFC8040 B.3.2: depth=3. The key leafs of the list entries at
+			// the depth are kept to identify the entries.
+			depth: 3,
+			present: []string{"library/artist[name='Foo Fighters']/name", "library/artist-count",
+				"playlist[name=Foo-One]/description", "playlist[name=Foo-One]/song[index=1]/index", "player/gap"},
+			absent: []string{"library/artist[name='Foo Fighters']/album", "playlist[name=Foo-One]/song[index=1]/id"},
+		},
+		{
+			depth: 5,
+			present: []string{"library/artist[name='Foo Fighters']/album[name='Wasting Light']/year",
+				"library/artist[name='Foo Fighters']/album[name='Wasting Light']/song[name='Wasting Light']/name"},
+			absent: []string{"library/artist[name='Foo Fighters']/album[name='Wasting Light']/song[name='Wasting Light']/length"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.depth), func(t *testing.T) {
+			node := applyJukebox(t, rfcJukebox, &QueryParam{Content: "all", Depth: tt.depth, WithDefaults: "explicit"})
+			if tt.depth == 1 && node.Len() != 0 {
+				t.Errorf("Apply() depth=1: %d children, want 0", node.Len())
+			}
+			for _, p := range tt.present {
+				if got, _ := yangtree.Find(node, p); len(got) == 0 {
+					t.Errorf("Apply() depth=%d: %s not found", tt.depth, p)
+				}
+			}
+			for _, p := range tt.absent {
+				if got, _ := yangtree.Find(node, p); len(got) != 0 {
+					t.Errorf("Apply() depth=%d: %s found", tt.depth, p)
+				}
+			}
+		})
+	}
+}
@@ -1,33 +1,31 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/gofiber/fiber"
 	"github.com/neoul/yangtree"
 )
 
-func (rc *RESTCtrl) Request(c *fiber.Ctx, rdata *RespData, schema *yangtree.SchemaNode) error {
-	// rpc, err := yangtree.New(schema)
-	// if err != nil {
-	// 	return rc.ResponseError(c, fiber.StatusInternalServerError, ETypeApplication,
-	// 		ETagOperationFailed, fmt.Errorf("unable to load the schema of the rpc %v: %v", schema, err))
-	// }
-
-	// contentType := string(c.Request().Header.ContentType())
-	// switch {
-	// case strings.HasSuffix(contentType, "json"):
-	// 	err = yangtree.UnmarshalJSON(rpc, c.Body())
-	// case strings.HasSuffix(contentType, "yaml"):
-	// 	err = yangtree.UnmarshalYAML(rpc, c.Body())
-	// case strings.HasSuffix(contentType, "xml"):
-	// 	err = yangtree.UnmarshalXML(rpc, c.Body())
-	// default:
-	// 	return rc.SetError(c, rdata, fiber.StatusNotImplemented, ETypeProtocol,
-	// 		ETagInvalidValue, errors.New("not supported Content-Type"))
-	// }
-	// if err != nil {
-	// 	return rc.SetError(c, rdata, fiber.StatusBadRequest,
-	// 		ETypeApplication, ETagMarlformedMessage,
-	// 		fmt.Errorf("parsing rpc failed: %v", err))
-	// }
+// Unmarshal() decodes the message-body of the request into the node
+// according to the Content-Type of the request.
+func (rc *RESTCtrl) Unmarshal(c *fiber.Ctx, node yangtree.DataNode) error {
+	var err error
+	contentType := string(c.Request().Header.ContentType())
+	switch contentType {
+	case "text/json", "application/json", "application/yang-data+json":
+		err = yangtree.UnmarshalJSON(node, c.Body())
+	case "text/yaml", "application/yaml", "application/yang-data+yaml":
+		err = yangtree.UnmarshalYAML(node, c.Body())
+	case "text/xml", "application/xml", "application/yang-data+xml":
+		err = yangtree.UnmarshalXML(node, c.Body())
+	default:
+		return NewError(rc, fiber.StatusUnsupportedMediaType, ETypeTransport,
+			ETagInvalidValue, c.Path(), "not supported Content-Type in request header")
+	}
+	if err != nil {
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagMarlformedMessage, c.Path(), fmt.Sprintf("parsing error: %v", err))
+	}
 	return nil
 }
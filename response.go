@@ -8,11 +8,25 @@ import (
 	"github.com/neoul/yangtree"
 )
 
+// acceptPatch is the media types of the PATCH message-body accepted.
+const acceptPatch = "application/yang-data+xml, application/yang-data+json, application/yang-data+yaml, " +
+	"application/yang-patch+xml, application/yang-patch+json"
+
 //
 type RespData struct {
 	Nodes   []yangtree.DataNode
 	isGroup bool // true if searching multipleNnodes
 	Status  int  // HTTP response status
+	// true if the default nodes are tagged (with-defaults=report-all-tagged)
+	tagDefaults bool
+}
+
+// acceptedType() returns the content type of the response negotiated by
+// the Accept header.
+func acceptedType(c *fiber.Ctx) string {
+	return c.Accepts("*/*", "text/json", "text/yaml", "text/xml",
+		"application/xml", "application/json", "application/yaml",
+		"application/yang-data+xml", "application/yang-data+json", "application/yang-data+yaml")
 }
 
 func (rc *RESTCtrl) Response(c *fiber.Ctx, rdata *RespData) error {
@@ -20,9 +34,7 @@ func (rc *RESTCtrl) Response(c *fiber.Ctx, rdata *RespData) error {
 	c.Set("Cache-Control", "no-cache")
 
 	marshal := yangtree.MarshalXMLIndent
-	accepts := c.Accepts("*/*", "text/json", "text/yaml", "text/xml",
-		"application/xml", "application/json", "application/yaml",
-		"application/yang-data+xml", "application/yang-data+json", "application/yang-data+yaml")
+	accepts := acceptedType(c)
 	switch {
 	case accepts == "*/*": // if all types are allowed
 		c.Set("Content-Type", "application/yang-data+xml")
@@ -40,39 +52,69 @@ func (rc *RESTCtrl) Response(c *fiber.Ctx, rdata *RespData) error {
 		c.Set("Content-Type", "application/yang-data+xml")
 		marshal = yangtree.MarshalXMLIndent
 	}
-	switch c.Method() {
-	case "GET": // netconf get, get-config
-		if len(rdata.Nodes) == 0 {
+	if len(rdata.Nodes) == 0 {
+		if c.Method() == "GET" || c.Method() == "HEAD" { // netconf get, get-config
 			return NewError(rc, fiber.StatusNotFound, ETypeApplication,
 				ETagDataMissing, c.Path(), "resource not found")
 		}
-		var err error
-		var b []byte
-		var node yangtree.DataNode
-		if rdata.isGroup || len(rdata.Nodes) > 1 {
-			node, err = yangtree.ConvertToGroup(rdata.Nodes[0].Schema(), rdata.Nodes)
-			if err != nil {
-				// StatusPreconditionFailed - for GET or HEAD
-				// when If-Unmodified-Since or If-None-Match headers is not fulfilled.
-				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
-					ETagOperationFailed, c.Path(), err)
-			}
-		} else {
-			node = rdata.Nodes[0]
+		// netconf rpc, edit-config
+		if rdata.Status != 0 {
+			c.Status(rdata.Status)
 		}
-		b, err = marshal(node, "", " ", yangtree.RepresentItself{})
+		return nil
+	}
+	var err error
+	var b []byte
+	var node yangtree.DataNode
+	if rdata.isGroup || len(rdata.Nodes) > 1 {
+		node, err = yangtree.ConvertToGroup(rdata.Nodes[0].Schema(), rdata.Nodes)
 		if err != nil {
+			// StatusPreconditionFailed - for GET or HEAD
+			// when If-Unmodified-Since or If-None-Match headers is not fulfilled.
 			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
 				ETagOperationFailed, c.Path(), err)
 		}
-		if rdata.Status != 0 {
-			c.Status(rdata.Status)
+	} else {
+		node = rdata.Nodes[0]
+	}
+	b, err = marshal(node, "", " ", yangtree.RepresentItself{})
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	if rdata.tagDefaults {
+		nodes := []yangtree.DataNode{node}
+		if rdata.isGroup || len(rdata.Nodes) > 1 {
+			nodes = rdata.Nodes // the group node is not encoded.
 		}
-		return c.Send(b)
-	case "POST": // netconf rpc
-		if rdata.Status != 0 {
-			c.Status(rdata.Status)
+		contentType := string(c.Response().Header.ContentType())
+		switch {
+		case strings.HasSuffix(contentType, "xml"):
+			b, err = tagDefaultsXML(b, defaultPaths(nodes))
+		case strings.HasSuffix(contentType, "json"):
+			b, err = tagDefaultsJSON(b, defaultPaths(nodes))
+		}
+		if err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, c.Path(), err)
+		}
+	}
+	if rdata.Status != 0 {
+		c.Status(rdata.Status)
+	}
+	return c.Send(b)
+}
+
+// ResponseOptions() responds to the OPTIONS request with the Allow header
+// that lists the HTTP methods allowed for the resource.
+func (rc *RESTCtrl) ResponseOptions(c *fiber.Ctx, methods ...string) error {
+	c.Set("Server", "open-restconf")
+	c.Set("Allow", strings.Join(methods, ", "))
+	for i := range methods {
+		if methods[i] == "PATCH" {
+			c.Set("Accept-Patch", acceptPatch)
 		}
 	}
+	c.Status(fiber.StatusOK)
 	return nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -10,128 +11,185 @@ import (
 	"github.com/neoul/yangtree"
 )
 
-func rpathKey2xpathKey(xpathB *strings.Builder, schema *yangtree.SchemaNode, keystr *string) {
-	var keynodes []string
-	if len(schema.Keyname) == 1 {
-		keynodes = []string{*keystr}
-	} else {
-		keynodes = strings.Split(*keystr, ",")
+// nodeSegment() returns the path segment (api-identifier or list-instance)
+// of the data node.
+func nodeSegment(node yangtree.DataNode) *APIPathSegment {
+	schema := node.Schema()
+	seg := &APIPathSegment{Name: schema.Name}
+	if id := apiIdentifier(schema); id != schema.Name {
+		seg.Module = moduleName(schema)
 	}
-	for j := range keynodes {
-		if j < len(schema.Keyname) {
-			xpathB.WriteString("[")
-			xpathB.WriteString(schema.Keyname[j])
-			xpathB.WriteString("=")
-			xpathB.WriteString(keynodes[j])
-			xpathB.WriteString("]")
+	switch {
+	case node.IsLeafList():
+		seg.Values = []string{node.ValueString()}
+	case schema.IsList() && len(schema.Keyname) > 0:
+		for i := range schema.Keyname {
+			seg.Values = append(seg.Values, node.GetValueString(schema.Keyname[i]))
+		}
+	}
+	return seg
+}
+
+// Node2RPath() converts the data node to RESTCONF URI(Route Path) following
+// the root ("/restconf/data"). The key values are percent-encoded and the
+// names are module-qualified if required. It is the reverse of RPath2XPath().
+func Node2RPath(node yangtree.DataNode) string {
+	var segments []*APIPathSegment
+	for n := node; n != nil && n.Parent() != nil; n = n.Parent() {
+		segments = append([]*APIPathSegment{nodeSegment(n)}, segments...)
+	}
+	return FormatAPIPath(segments)
+}
+
+// Schema2RPath() converts the schema node to RESTCONF URI(Route Path)
+// following the root schema node. The list nodes are not list-instances.
+func Schema2RPath(root, schema *yangtree.SchemaNode) string {
+	var segments []*APIPathSegment
+	for s := schema; s != nil && s != root; s = dataParent(s) {
+		if moduleName(s) == "" {
+			break // the root of the schema tree
+		}
+		seg := &APIPathSegment{Name: s.Name}
+		if id := apiIdentifier(s); id != s.Name {
+			seg.Module = moduleName(s)
 		}
+		segments = append([]*APIPathSegment{seg}, segments...)
 	}
+	return FormatAPIPath(segments)
 }
 
-// RPath2XPath() converts RESTCONF URI(Route Path) to XPath
+// RPath2XPath() converts RESTCONF URI(Route Path) to XPath. The uri is the
+// api-path following the root ("/restconf/data"). It returns the
+// *APIPathError if the uri is not valid.
 func RPath2XPath(schema *yangtree.SchemaNode, uri *string) (*yangtree.SchemaNode, string, error) {
-	var xpathB strings.Builder
-	pathnodes := strings.Split(*uri, "/")
+	segments, err := ParseAPIPath(*uri)
+	if err != nil {
+		return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: *uri, Msg: err.Error()}
+	}
+	var xpathB, rpathB strings.Builder
 	snode := schema
-	var keystr string
-	for i := range pathnodes {
-		if index := strings.Index(pathnodes[i], "="); index >= 0 {
-			if keystr != "" {
-				return nil, "", fmt.Errorf("failed to extract the key for %s", snode)
-			}
-			s := snode.GetSchema(pathnodes[i][:index])
-			if s == nil {
-				return nil, "", fmt.Errorf("unable to find schema %s", pathnodes[i][:index])
-			}
-			snode = s
-			keystr = pathnodes[i][index+1:]
-			xpathB.WriteString(s.Name)
-		} else if pathnodes[i] == "" {
-			continue
-		} else {
-			s := snode.GetSchema(pathnodes[i])
-			if s == nil {
-				if len(keystr) > 0 {
-					keystr = keystr + "/" + pathnodes[i]
-					continue
+	for i, seg := range segments {
+		rpathB.WriteString("/")
+		rpathB.WriteString(seg.Raw)
+		s, etag, msg := resolveSegment(snode, seg)
+		if s == nil {
+			return nil, "", &APIPathError{Tag: etag, Path: rpathB.String(), Msg: msg}
+		}
+		snode = s
+		if i > 0 {
+			xpathB.WriteString("/")
+		}
+		xpathB.WriteString(s.Name)
+		switch {
+		case seg.Values == nil:
+			// RFC8040 3.5.3: the key values of the list must be encoded
+			// except the target list resource (all entries).
+			if s.IsList() && i < len(segments)-1 {
+				if len(s.Keyname) == 0 {
+					return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+						Msg: fmt.Sprintf("unable to access a single instance of %s without keys", s.Name)}
 				}
-				return nil, "", fmt.Errorf("unable to find schema %s", pathnodes[i])
+				return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+					Msg: fmt.Sprintf("missing key values of %s", s.Name)}
 			}
-			if keystr != "" {
-				rpathKey2xpathKey(&xpathB, snode, &keystr)
-				xpathB.WriteString("/")
-				keystr = ""
+		case s.IsLeafList():
+			if len(seg.Values) != 1 {
+				return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+					Msg: fmt.Sprintf("%s requires a single value", s.Name)}
 			}
-			snode = s
-			xpathB.WriteString(pathnodes[i])
-			if len(pathnodes) != i+1 {
-				xpathB.WriteString("/")
+			value, err := xpathValue(seg.Values[0])
+			if err != nil {
+				return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(), Msg: err.Error()}
+			}
+			xpathB.WriteString("[.=")
+			xpathB.WriteString(value)
+			xpathB.WriteString("]")
+		case s.IsList() && len(s.Keyname) == 0:
+			// RFC8040 3.5.3: non-configuration lists are not required to
+			// define keys. In this case, a single list instance cannot be accessed.
+			return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+				Msg: fmt.Sprintf("unable to access a single instance of %s without keys", s.Name)}
+		case !s.IsList():
+			return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+				Msg: fmt.Sprintf("%s is not a list-instance", s.Name)}
+		case len(seg.Values) != len(s.Keyname):
+			return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(),
+				Msg: fmt.Sprintf("%s requires %d key values, but %d given",
+					s.Name, len(s.Keyname), len(seg.Values))}
+		default:
+			for j := range s.Keyname {
+				value, err := xpathValue(seg.Values[j])
+				if err != nil {
+					return nil, "", &APIPathError{Tag: ETagInvalidValue, Path: rpathB.String(), Msg: err.Error()}
+				}
+				xpathB.WriteString("[")
+				xpathB.WriteString(s.Keyname[j])
+				xpathB.WriteString("=")
+				xpathB.WriteString(value)
+				xpathB.WriteString("]")
 			}
 		}
 	}
-	if keystr != "" {
-		rpathKey2xpathKey(&xpathB, snode, &keystr)
-	}
 	return snode, xpathB.String(), nil
 }
 
+// pathError() returns the RESTCONF error of the api-path resolution.
+func (rc *RESTCtrl) pathError(root string, err error) error {
+	if pe, ok := err.(*APIPathError); ok {
+		return NewError(rc, pe.Tag.Status(), ETypeProtocol, pe.Tag, root+pe.Path, pe.Msg)
+	}
+	return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue, root, err)
+}
+
 func InstallRouteRPC(app *fiber.App, rc *RESTCtrl) error {
 	app.Group("/restconf/operations/", func(c *fiber.Ctx) error {
-		if c.Method() != "POST" {
+		switch c.Method() {
+		case "POST", "OPTIONS":
+		default:
 			return NewError(rc, fiber.StatusMethodNotAllowed, ETypeTransport,
 				ETagAccessDenied, c.Path(), "HTTP POST only allowed for rpc")
 		}
-		rc.Lock()
-		defer rc.Unlock()
-		rpcname := c.Path()[len("/restconf/operations/"):]
-		schema := rc.schemaOperations.GetSchema(rpcname)
-		if schema == nil {
+		// The rpc handler runs without the datastore lock.
+		rpcname := c.Path()[len("/restconf/operations"):]
+		schema, _, err := RPath2XPath(rc.schemaOperations, &rpcname)
+		if err != nil {
+			return rc.pathError("/restconf/operations", err)
+		}
+		if schema.RPC == nil {
 			return NewError(rc, fiber.StatusNotFound, ETypeProtocol, ETagUnknownElement,
 				c.Path(), fmt.Errorf("unable to identify rpc %s", rpcname))
 		}
-
-		rpc, err := yangtree.New(schema)
-		if err != nil {
-			return NewError(rc, fiber.StatusInternalServerError, ETypeProtocol,
-				ETagOperationFailed, c.Path(), err)
+		if c.Method() == "OPTIONS" {
+			return rc.ResponseOptions(c, "OPTIONS", "POST")
 		}
+		return rc.Invoke(c, schema, nil, "")
+	})
+	return nil
+}
 
-		if schema.HasRPCInput() {
-			// log.Println(string(c.Body()))
-			contentType := string(c.Request().Header.ContentType())
-			switch contentType {
-			case "text/json", "application/json", "application/yang-data+json":
-				err = yangtree.UnmarshalJSON(rpc, c.Body())
-			case "text/yaml", "application/yaml", "application/yang-data+yaml":
-				err = yangtree.UnmarshalYAML(rpc, c.Body())
-			case "text/xml", "application/xml", "application/yang-data+xml":
-				err = yangtree.UnmarshalXML(rpc, c.Body())
-			default:
-				return NewError(rc, fiber.StatusUnsupportedMediaType, ETypeTransport,
-					ETagInvalidValue, c.Path(), "not supported Content-Type in request header")
-			}
-			// log.Println(rpc.Values()...)
-			if err != nil {
-				return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
-					ETagMarlformedMessage, c.Path(), fmt.Sprintf("parsing error: %v", err))
-			}
-		}
-		// invoke user-callback interface
-		// check the result of the user-callback
+// isMultiInstance() returns true if the xpath identifies all instances of
+// the list or leaf-list of the schema.
+func isMultiInstance(schema *yangtree.SchemaNode, xpath string) bool {
+	return (schema.IsList() || schema.IsLeafList()) && !strings.HasSuffix(xpath, "]")
+}
 
-		if schema.HasRPCOutput() {
-			if output := rpc.Get("output"); output != nil {
-				return rc.Response(c, &RespData{Nodes: []yangtree.DataNode{output}})
-			}
+// allowedMethods() returns the HTTP methods allowed for the resource of the schema.
+func (rc *RESTCtrl) allowedMethods(schema *yangtree.SchemaNode) []string {
+	switch {
+	case schema == rc.schemaData: // datastore resource
+		if rc.AllowDatastoreDelete {
+			return []string{"OPTIONS", "HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"}
 		}
-
-		// If the RPC operation is invoked without errors and if the "rpc" or
-		// "action" statement has no "output" section, the response message
-		// MUST NOT include a message-body and MUST send a "204 No Content"
-		// status-line instead.
-		return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
-	})
-	return nil
+		return []string{"OPTIONS", "HEAD", "GET", "POST", "PUT", "PATCH"}
+	case schema.RPC != nil: // rpc or action
+		return []string{"OPTIONS", "POST"}
+	case schema.IsState: // non-configuration data
+		return []string{"OPTIONS", "HEAD", "GET"}
+	case schema.IsDir():
+		return []string{"OPTIONS", "HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"}
+	default:
+		return []string{"OPTIONS", "HEAD", "GET", "PUT", "PATCH", "DELETE"}
+	}
 }
 
 func InstallRouteData(app *fiber.App, rc *RESTCtrl) error {
@@ -140,12 +198,23 @@ func InstallRouteData(app *fiber.App, rc *RESTCtrl) error {
 		uri := c.Path()[len("/restconf/data"):]
 		schema, xpath, err := RPath2XPath(rc.schemaData, &uri)
 		if err != nil {
-			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
-				ETagBadElement, c.Path(), err)
+			return rc.pathError("/restconf/data", err)
 		}
 		log.Println("requested data node:", schema)
+		if schema.RPC != nil {
+			// The action handler runs without the datastore lock.
+			switch method {
+			case "OPTIONS":
+				return rc.ResponseOptions(c, rc.allowedMethods(schema)...)
+			case "POST":
+				return rc.Action(c, schema, xpath)
+			default:
+				return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol,
+					ETagOperationNotSupported, c.Path(), "HTTP POST only allowed for action")
+			}
+		}
 		switch method {
-		case "GET":
+		case "GET", "HEAD", "OPTIONS":
 			rc.RLock()
 			defer rc.RUnlock()
 		default:
@@ -153,9 +222,25 @@ func InstallRouteData(app *fiber.App, rc *RESTCtrl) error {
 			defer rc.Unlock()
 		}
 		// requestid := c.GetRespHeader("X-Request-Id")
+		if method != "OPTIONS" {
+			notModified, err := rc.CheckPreconditions(c, xpath)
+			if err != nil {
+				return err
+			}
+			if notModified {
+				rc.SetEntityTag(c, xpath)
+				c.Status(fiber.StatusNotModified)
+				return nil
+			}
+		}
 		switch method {
-		case "GET":
-
+		case "OPTIONS":
+			return rc.ResponseOptions(c, rc.allowedMethods(schema)...)
+		case "GET", "HEAD":
+			q, err := rc.ParseQuery(c, schema)
+			if err != nil {
+				return err
+			}
 			found, err := yangtree.Find(rc.DataRoot, xpath)
 			if err != nil {
 				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
@@ -165,7 +250,21 @@ func InstallRouteData(app *fiber.App, rc *RESTCtrl) error {
 				return NewError(rc, fiber.StatusNotFound, ETypeApplication,
 					ETagDataMissing, c.Path(), "unable to find the requested resource")
 			}
-			return rc.Response(c, &RespData{Nodes: found})
+			rc.SetEntityTag(c, xpath)
+			return rc.Response(c, &RespData{Nodes: q.Apply(found),
+				isGroup:     isMultiInstance(schema, xpath),
+				tagDefaults: q.WithDefaults == "report-all-tagged"})
+		case "POST":
+			return rc.Post(c, schema, xpath)
+		case "PUT":
+			return rc.Put(c, schema, xpath)
+		case "PATCH":
+			if isYANGPatch(c) {
+				return rc.YANGPatch(c, schema, uri, xpath)
+			}
+			return rc.Patch(c, schema, xpath)
+		case "DELETE":
+			return rc.Delete(c, schema, xpath)
 		default:
 			return NewError(rc, fiber.StatusMethodNotAllowed, ETypeProtocol, ETagOperationFailed,
 				uri, fmt.Errorf("HTTP %s not implemented yet", method))
@@ -223,7 +322,9 @@ func InstallRouteRESTCONF(app *fiber.App, rc *RESTCtrl) error {
 	}
 	app.All("/restconf", func(c *fiber.Ctx) error {
 		switch c.Method() {
-		case "GET":
+		case "GET", "HEAD":
+		case "OPTIONS":
+			return rc.ResponseOptions(c, "OPTIONS", "HEAD", "GET")
 		default:
 			return NewError(rc, fiber.StatusMethodNotAllowed, ETypeTransport,
 				ETagAccessDenied, c.Path(), "HTTP GET only allowed for the path")
@@ -232,7 +333,9 @@ func InstallRouteRESTCONF(app *fiber.App, rc *RESTCtrl) error {
 	})
 	app.All("/restconf/yang-library-version", func(c *fiber.Ctx) error {
 		switch c.Method() {
-		case "GET":
+		case "GET", "HEAD":
+		case "OPTIONS":
+			return rc.ResponseOptions(c, "OPTIONS", "HEAD", "GET")
 		default:
 			return NewError(rc, fiber.StatusMethodNotAllowed, ETypeTransport,
 				ETagAccessDenied, c.Path(), "HTTP GET only allowed for the path")
@@ -253,7 +356,9 @@ func InstallRouteRESTCONF(app *fiber.App, rc *RESTCtrl) error {
 func InstallRouteHostMeta(app *fiber.App, rc *RESTCtrl) error {
 	app.All("/.well-known/host-meta", func(c *fiber.Ctx) error {
 		switch c.Method() {
-		case "GET":
+		case "OPTIONS":
+			return rc.ResponseOptions(c, "OPTIONS", "HEAD", "GET")
+		case "GET", "HEAD":
 			c.Links(fmt.Sprint(c.BaseURL() + "/restconf"))
 			hdr := &(c.Response().Header)
 			hdr.Add("Content-Type", "application/xrd+xml")
@@ -275,7 +380,9 @@ func InstallRouteHostMeta(app *fiber.App, rc *RESTCtrl) error {
 func InstallRouteSchemaPath(app *fiber.App, rc *RESTCtrl) error {
 	app.All("/.schema", func(c *fiber.Ctx) error {
 		switch c.Method() {
-		case "GET":
+		case "OPTIONS":
+			return rc.ResponseOptions(c, "OPTIONS", "HEAD", "GET")
+		case "GET", "HEAD":
 			hdr := &(c.Response().Header)
 			hdr.Add("Content-Type", "application/yang-data+json")
 			fmt.Fprintf(c, "[\n")
@@ -294,6 +401,15 @@ func InstallRouteSchemaPath(app *fiber.App, rc *RESTCtrl) error {
 	return nil
 }
 
+// yangFileURI() returns the percent-encoded URI of the YANG module file.
+func yangFileURI(file string) string {
+	elems := strings.Split(filepath.ToSlash(file), "/")
+	for i := range elems {
+		elems[i] = url.PathEscape(elems[i])
+	}
+	return "/yang/" + strings.Join(elems, "/")
+}
+
 func InstallRouteYANGModules(app *fiber.App, library yangtree.DataNode, yangfiles []string) error {
 	yfiles, _ := yangtree.FindYangFiles(yangfiles)
 	for i := range yfiles {
@@ -305,10 +421,26 @@ func InstallRouteYANGModules(app *fiber.App, library yangtree.DataNode, yangfile
 		}
 		if node, _ := yangtree.Find(library,
 			fmt.Sprintf("module[name=%s][revision=%s]", mname[0], mname[1])); len(node) > 0 {
+			// The file is served at the percent-encoded URI advertised
+			// in the schema leaf of the module.
+			uri := yangFileURI(yfiles[i])
+			abspath, err := filepath.Abs(yfiles[i])
+			if err != nil {
+				return err
+			}
+			// SendFile() takes the file path as the request URI to be
+			// decoded, so the path is percent-encoded.
+			file := (&url.URL{Path: filepath.ToSlash(abspath)}).EscapedPath()
 			node[0].SetValue(map[interface{}]interface{}{
-				"schema": "yang/" + yfiles[i],
+				"schema": uri,
+			})
+			app.Get(uri, func(c *fiber.Ctx) error {
+				if err := c.SendFile(file); err != nil {
+					return fiber.NewError(fiber.StatusNotFound, err.Error())
+				}
+				c.Set(fiber.HeaderContentType, "application/yang")
+				return nil
 			})
-			app.Static("/yang/"+yfiles[i], yfiles[i])
 		}
 	}
 	return nil
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/gofiber/fiber"
 	"github.com/neoul/yangtree"
 )
 
@@ -14,19 +22,41 @@ func Test_RPath2XPath(t *testing.T) {
 		"/modules-state/module=yangtree,2020-08-18/",
 		"/modules-state/module=yangtree,2020-08-18",
 		"/modules-state/module",
+		"/modules-state/module=1%2F1,2020-08-18/",
+		"/modules-state/module=a%2Cb,2020-08-18",
+		"/modules-state/module=,2020-08-18",
 		"/modules-state/module=1/1,2020-08-18/",
-		"/modules-state/module=1/1/1,2020-08-18/",
 		"/modules-state/module=A,2020-08-18/UNKNOWN",
+		"/modules-state/module/namespace",
+		"/modules-state=A",
 		"/modules-state/UNKNOWN",
+		"/modules-state/module=A,%ZZ",
+		"/ietf-yang-library:modules-state/module=yangtree,2020-08-18",
+		"/ietf-yang-library:modules-state/ietf-yang-library:module-set-id",
+		"/example-jukebox:modules-state",
+		"/modules-state/module=yangtree,2020-08-18/feature=a%2Fb",
+		"/modules-state/module=yangtree,2020-08-18/feature=a,b",
+		"/modules-state/module=a'b%22c,2020-08-18",
 	}
 	xpath := []string{
 		"modules-state/module[name=yangtree][revision=2020-08-18]/namespace",
 		"modules-state/module[name=yangtree][revision=2020-08-18]",
 		"modules-state/module[name=yangtree][revision=2020-08-18]",
 		"modules-state/module",
-		"modules-state/module[name=1/1][revision=2020-08-18]",
-		"modules-state/module[name=1/1/1][revision=2020-08-18]",
-		"modules-state/module[name=A][revision=2020-08-18/UNKNOWN]", // unmatched UNKNOWN becomes a key
+		"modules-state/module[name='1/1'][revision=2020-08-18]",
+		"modules-state/module[name=a,b][revision=2020-08-18]",
+		"modules-state/module[name=''][revision=2020-08-18]",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"modules-state/module[name=yangtree][revision=2020-08-18]",
+		"modules-state/module-set-id",
+		"",
+		"modules-state/module[name=yangtree][revision=2020-08-18]/feature[.='a/b']",
+		"",
 		"",
 	}
 	wanterr := []bool{
@@ -37,7 +67,18 @@ func Test_RPath2XPath(t *testing.T) {
 		false,
 		false,
 		false,
+		true, // the key count mismatched
+		true, // UNKNOWN is not a key
+		true, // missing keys
+		true, // not a list
 		true,
+		true, // invalid percent-encoding
+		false,
+		false,
+		true, // unknown-namespace
+		false,
+		true, // multiple leaf-list values
+		true, // both ' and " in the key value
 	}
 
 	type test struct {
@@ -68,3 +109,199 @@ func Test_RPath2XPath(t *testing.T) {
 		})
 	}
 }
+
+func Test_Node2RPath(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpath := "modules-state/module[name=yangtree][revision=2020-08-18]/namespace"
+	if err := yangtree.SetValue(root, xpath, nil, "urn:yangtree"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := yangtree.Find(root, xpath)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("unable to find %s: %v", xpath, err)
+	}
+	want := "/ietf-yang-library:modules-state/module=yangtree,2020-08-18/namespace"
+	rpath := Node2RPath(found[0])
+	if rpath != want {
+		t.Errorf("Node2RPath() = %v, want %v", rpath, want)
+	}
+	schema, got, err := RPath2XPath(rc.schemaData, &rpath)
+	if err != nil || got != xpath {
+		t.Errorf("RPath2XPath(%v) = %v, %v, want %v", rpath, got, err, xpath)
+	}
+	want = "/ietf-yang-library:modules-state/module/namespace"
+	if got := Schema2RPath(rc.schemaData, schema); got != want {
+		t.Errorf("Schema2RPath() = %v, want %v", got, want)
+	}
+}
+
+func Test_RPathRoundTrip(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		artist string
+		album  string
+	}{
+		{artist: "Foo Fighters", album: "Wasting Light"},
+		{artist: "a,b", album: ",,"},
+		{artist: "AC/DC", album: "/"},
+		{artist: "Guns N' Roses", album: "'"},
+		{artist: "a,b/c'd", album: "x, y/z's"},
+		{artist: "", album: "empty artist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.artist+" "+tt.album, func(t *testing.T) {
+			artist, err := xpathValue(tt.artist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			album, err := xpathValue(tt.album)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xpath := fmt.Sprintf("jukebox/library/artist[name=%s]/album[name=%s]/year", artist, album)
+			if err := yangtree.SetValue(root, xpath, nil, "2011"); err != nil {
+				t.Fatal(err)
+			}
+			found, err := yangtree.Find(root, xpath)
+			if err != nil || len(found) != 1 {
+				t.Fatalf("unable to find %s: %v", xpath, err)
+			}
+			rpath := Node2RPath(found[0])
+			schema, got, err := RPath2XPath(rc.schemaData, &rpath)
+			if err != nil {
+				t.Fatalf("RPath2XPath(%s) error = %v", rpath, err)
+			}
+			again, err := yangtree.Find(root, got)
+			if err != nil || len(again) != 1 || again[0] != found[0] {
+				t.Errorf("RPath2XPath(%s) = %s, not identifying the node of %s: %v", rpath, got, xpath, err)
+			}
+			want := "/example-jukebox:jukebox/library/artist/album/year"
+			if got := Schema2RPath(rc.schemaData, schema); got != want {
+				t.Errorf("Schema2RPath() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func Test_InstallRouteYANGModules(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+	// The directory name includes the space to be percent-encoded.
+	ydir, err := os.MkdirTemp("testdata", "yang modules ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ydir)
+	b, err := os.ReadFile("modules/example/example-jukebox.yang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(ydir, "example-jukebox.yang")
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	library := rc.rootSchema.GetYangLibrary()
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteYANGModules(app, library, []string{ydir}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := yangtree.Find(library, "module[name=example-jukebox]/schema")
+	if err != nil || len(found) != 1 {
+		t.Fatalf("schema of example-jukebox not found: %v", err)
+	}
+	uri := found[0].ValueString()
+	if want := yangFileURI(file); uri != want || !strings.Contains(uri, "%20") {
+		t.Errorf("schema = %s, want %s", uri, want)
+	}
+	resp, err := app.Test(httptest.NewRequest("GET", uri, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", uri, resp.StatusCode, fiber.StatusOK)
+	}
+	if ct := resp.Header.Get(fiber.HeaderContentType); ct != "application/yang" {
+		t.Errorf("GET %s Content-Type = %q, want application/yang", uri, ct)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("GET %s returned %d bytes, want the module file of %d bytes", uri, len(got), len(b))
+	}
+}
+
+func Test_Options(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		allow  string
+		patch  bool // Accept-Patch is present
+	}{
+		{name: "datastore", path: "/restconf/data", status: fiber.StatusOK,
+			allow: "OPTIONS, HEAD, GET, POST, PUT, PATCH", patch: true},
+		{name: "container", path: "/restconf/data/example-jukebox:jukebox/library", status: fiber.StatusOK,
+			allow: "OPTIONS, HEAD, GET, POST, PUT, PATCH, DELETE", patch: true},
+		{name: "config false", path: "/restconf/data/example-jukebox:jukebox/library/artist-count",
+			status: fiber.StatusOK, allow: "OPTIONS, HEAD, GET"},
+		{name: "rpc", path: "/restconf/operations/example-jukebox:play", status: fiber.StatusOK,
+			allow: "OPTIONS, POST"},
+		{name: "unknown rpc", path: "/restconf/operations/example-jukebox:stop", status: fiber.StatusNotFound},
+	}
+	_, app := newJukebox(t, `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]}}}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, app, "OPTIONS", tt.path, "")
+			if resp.StatusCode != tt.status {
+				t.Fatalf("OPTIONS status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if got := resp.Header.Get("Accept-Patch") != ""; got != tt.patch {
+				t.Errorf("Accept-Patch present = %v, want %v", got, tt.patch)
+			}
+		})
+	}
+}
+
+func Test_Head(t *testing.T) {
+	_, app := newJukebox(t, `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]}}}`)
+	for _, path := range []string{
+		"/restconf/data/example-jukebox:jukebox/library/artist=A",
+		"/restconf/data/example-jukebox:jukebox",
+	} {
+		t.Run(path, func(t *testing.T) {
+			get := doRequest(t, app, "GET", path, "")
+			head := doRequest(t, app, "HEAD", path, "")
+			if get.StatusCode != fiber.StatusOK || head.StatusCode != get.StatusCode {
+				t.Fatalf("HEAD status = %d, GET status = %d", head.StatusCode, get.StatusCode)
+			}
+			for _, h := range []string{fiber.HeaderContentType, fiber.HeaderETag, fiber.HeaderLastModified} {
+				if head.Header.Get(h) == "" || head.Header.Get(h) != get.Header.Get(h) {
+					t.Errorf("HEAD %s = %q, GET %s = %q", h, head.Header.Get(h), h, get.Header.Get(h))
+				}
+			}
+			body, err := io.ReadAll(head.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != 0 {
+				t.Errorf("HEAD body = %s, want empty", body)
+			}
+		})
+	}
+	resp := doRequest(t, app, "HEAD", "/restconf/data/example-jukebox:jukebox/library/artist=B", "")
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("HEAD of a missing resource = %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+}
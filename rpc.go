@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber"
+	"github.com/gofiber/fiber/middleware/basicauth"
+	"github.com/neoul/yangtree"
+)
+
+// RFC8040 3.6. Operation Resource
+
+// RPCRequest is the request of the rpc or action delivered to the RPCHandler.
+type RPCRequest struct {
+	Ctx    *fiber.Ctx           // the request context: nil for the asynchronous job
+	Path   string               // the request URI path
+	User   string               // the authenticated user: empty if not authenticated
+	Schema *yangtree.SchemaNode // the schema of the rpc or action
+	Input  yangtree.DataNode    // the input node validated: nil if no input
+	Target yangtree.DataNode    // the copy of the data node of the action: nil for rpc
+	Job    *Job                 // the job of the asynchronous execution: nil if synchronous
+
+	// The handler runs without the datastore lock. The data nodes of the
+	// datastore must be accessed and changed through the Data.
+	Data       *Datastore
+	TargetPath string // the data path of the Target to find it in the Data
+}
+
+// RPCHandler is the user-defined function to handle the rpc or action.
+// It returns the output node of the rpc or action or nil if no output. The
+// returned error should be a *RespError to report the RESTCONF error.
+type RPCHandler func(req *RPCRequest) (yangtree.DataNode, error)
+
+// rpcEntry is the handler of the rpc or action registered.
+type rpcEntry struct {
+	handler RPCHandler
+	async   bool
+}
+
+// RPCOption is the option of the rpc or action handler registration.
+type RPCOption func(e *rpcEntry)
+
+// Async() runs the handler of the rpc or action asynchronously as a job.
+// The request is answered with "202 Accepted" and the URI of the job
+// immediately, and the job is tracked in /restconf/data/open-restconf-jobs:jobs.
+func Async() RPCOption {
+	return func(e *rpcEntry) { e.async = true }
+}
+
+// newRPCEntry() returns the rpcEntry of the handler with the options.
+func newRPCEntry(handler RPCHandler, opts []RPCOption) *rpcEntry {
+	e := &rpcEntry{handler: handler}
+	for i := range opts {
+		opts[i](e)
+	}
+	return e
+}
+
+// findSchema() returns the schema node of the schema path that consists of
+// the api-identifiers from the root schema.
+func findSchema(root *yangtree.SchemaNode, path string) (*yangtree.SchemaNode, error) {
+	segments, err := ParseAPIPath(path)
+	if err != nil {
+		return nil, err
+	}
+	schema := root
+	for _, seg := range segments {
+		if seg.Values != nil {
+			return nil, fmt.Errorf("list-instance %s is not allowed in the schema path", seg.Raw)
+		}
+		s, _, msg := resolveSegment(schema, seg)
+		if s == nil {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		schema = s
+	}
+	return schema, nil
+}
+
+// RegisterAction() registers the handler of the action identified by the
+// schema path such as "/example-actions:interfaces/interface/reset".
+func (rc *RESTCtrl) RegisterAction(path string, handler RPCHandler, opts ...RPCOption) error {
+	schema, err := findSchema(rc.schemaData, path)
+	if err != nil {
+		return fmt.Errorf("restconf: unable to register action %s: %v", path, err)
+	}
+	if schema.RPC == nil {
+		return fmt.Errorf("restconf: %s is not action", path)
+	}
+	rc.handlers[schema] = newRPCEntry(handler, opts)
+	return nil
+}
+
+// RegisterRPC() registers the handler of the rpc identified by the schema
+// path such as "/example-ops:reboot".
+func (rc *RESTCtrl) RegisterRPC(path string, handler RPCHandler, opts ...RPCOption) error {
+	schema, err := findSchema(rc.schemaOperations, path)
+	if err != nil {
+		return fmt.Errorf("restconf: unable to register rpc %s: %v", path, err)
+	}
+	if schema.RPC == nil {
+		return fmt.Errorf("restconf: %s is not rpc", path)
+	}
+	rc.handlers[schema] = newRPCEntry(handler, opts)
+	return nil
+}
+
+// requestUser() returns the user authenticated by the authentication
+// middleware that sets the "user" local variable of the request. It returns
+// empty if the request is not authenticated; the user of the Authorization
+// header is not trusted since its credentials are not verified here.
+func requestUser(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(string); ok {
+		return user
+	}
+	return ""
+}
+
+// BasicAuth() returns the middleware of the HTTP basic authentication of
+// the users (name:password). The authenticated user is set to the "user"
+// local variable of the request for requestUser().
+func (rc *RESTCtrl) BasicAuth(users []string) (fiber.Handler, error) {
+	accounts := map[string]string{}
+	for i := range users {
+		account := strings.SplitN(users[i], ":", 2)
+		if len(account) != 2 || account[0] == "" {
+			return nil, fmt.Errorf("restconf: invalid user %q (name:password)", users[i])
+		}
+		accounts[account[0]] = account[1]
+	}
+	return basicauth.New(basicauth.Config{
+		Users:           accounts,
+		Realm:           "restconf",
+		ContextUsername: "user",
+		Unauthorized: func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="restconf"`)
+			return NewError(rc, fiber.StatusUnauthorized, ETypeProtocol,
+				ETagAccessDenied, c.Path(), "authentication required")
+		},
+	}), nil
+}
+
+// callHandler() calls the handler of the rpc or action and returns the output
+// node validated against the output schema.
+func (rc *RESTCtrl) callHandler(handler RPCHandler, req *RPCRequest) (yangtree.DataNode, error) {
+	schema := req.Schema
+	output, err := handler(req)
+	if err != nil {
+		if re, ok := err.(*RespError); ok {
+			return nil, re
+		}
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, req.Path, err)
+	}
+	if output == nil {
+		return nil, nil
+	}
+	if !schema.HasRPCOutput() || output.Schema() != schema.GetSchema("output") {
+		return nil, NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, req.Path, fmt.Sprintf("invalid output of %s", schema.Name))
+	}
+	if violations := Validate(output); len(violations) > 0 {
+		return nil, rc.violationError(fiber.StatusInternalServerError, req.Path, violations, true)
+	}
+	return output, nil
+}
+
+// Invoke() invokes the rpc or action of the schema with the input in the
+// message-body and responds the output. The target is the data node of
+// the action or nil for the rpc, and the tpath is the data path of the target.
+// It must be called without the datastore lock.
+func (rc *RESTCtrl) Invoke(c *fiber.Ctx, schema *yangtree.SchemaNode, target yangtree.DataNode, tpath string) error {
+	entry, ok := rc.handlers[schema]
+	if !ok {
+		return NewError(rc, fiber.StatusNotImplemented, ETypeProtocol,
+			ETagOperationNotSupported, c.Path(), fmt.Sprintf("%s not supported", schema.Name))
+	}
+	rpc, err := yangtree.New(schema)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeProtocol,
+			ETagOperationFailed, c.Path(), err)
+	}
+	var input yangtree.DataNode
+	if schema.HasRPCInput() {
+		if err := rc.Unmarshal(c, rpc); err != nil {
+			return err
+		}
+		if input = rpc.Get("input"); input == nil {
+			if input, err = yangtree.New(schema.GetSchema("input")); err == nil {
+				_, err = rpc.Insert(input, nil)
+			}
+			if err != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, c.Path(), err)
+			}
+		}
+		if violations := Validate(input); len(violations) > 0 {
+			return rc.violationError(fiber.StatusBadRequest, c.Path(), violations, false)
+		}
+	}
+	req := &RPCRequest{
+		Ctx:    c,
+		Path:   c.Path(),
+		User:   requestUser(c),
+		Schema: schema,
+		Input:  input,
+		Target: target,
+
+		Data:       &Datastore{rc: rc},
+		TargetPath: tpath,
+	}
+	if entry.async {
+		return rc.StartJob(c, entry.handler, req)
+	}
+	output, err := rc.callHandler(entry.handler, req)
+	if err != nil {
+		return err
+	}
+	if output != nil {
+		return rc.Response(c, &RespData{Nodes: []yangtree.DataNode{output}})
+	}
+	// If the RPC operation is invoked without errors and if the "rpc" or
+	// "action" statement has no "output" section, the response message
+	// MUST NOT include a message-body and MUST send a "204 No Content"
+	// status-line instead.
+	return rc.Response(c, &RespData{Status: fiber.StatusNoContent})
+}
+
+// actionTarget() returns the data node of the action identified by the xpath.
+func (rc *RESTCtrl) actionTarget(c *fiber.Ctx, xpath string) (yangtree.DataNode, string, error) {
+	rc.RLock()
+	defer rc.RUnlock()
+	if _, err := rc.CheckPreconditions(c, xpath); err != nil {
+		return nil, "", err
+	}
+	elems := splitXPath(xpath)
+	if len(elems) < 2 {
+		return nil, "", NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "unable to identify the data resource of the action")
+	}
+	tpath := strings.Join(elems[:len(elems)-1], "/")
+	found, err := yangtree.Find(rc.DataRoot, tpath)
+	if err != nil {
+		return nil, "", NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	switch len(found) {
+	case 0:
+		return nil, "", NewError(rc, fiber.StatusNotFound, ETypeApplication,
+			ETagDataMissing, c.Path(), "unable to find the data resource of the action")
+	case 1:
+		// The copy of the data node is safe to read after the lock is released.
+		return yangtree.Clone(found[0]), tpath, nil
+	default:
+		return nil, "", NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			c.Path(), "the request URI identifies multiple data resources")
+	}
+}
+
+// Action() invokes the action (YANG 1.1) of the data resource identified
+// by the xpath. (RFC8040 3.6)
+func (rc *RESTCtrl) Action(c *fiber.Ctx, schema *yangtree.SchemaNode, xpath string) error {
+	target, tpath, err := rc.actionTarget(c, xpath)
+	if err != nil {
+		return err
+	}
+	return rc.Invoke(c, schema, target, tpath)
+}
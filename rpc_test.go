@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_findSchema(t *testing.T) {
+	rc := loadSchema(*yangfiles, *dir, *excludes)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/ietf-yang-library:modules-state/module", want: "module"},
+		{path: "/modules-state/module/namespace", want: "namespace"},
+		{path: "/modules-state/module=yangtree,2020-08-18", wantErr: true},
+		{path: "/modules-state/UNKNOWN", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := findSchema(rc.schemaData, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Name != tt.want {
+				t.Errorf("findSchema() = %v, want %v", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requestUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		local string // the user set by the authentication middleware
+		auth  string
+		want  string
+	}{
+		{name: "unverified basic", auth: "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")), want: ""},
+		{name: "verified", local: "alice", auth: "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")), want: "alice"},
+		{name: "anonymous", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				if tt.local != "" {
+					c.Locals("user", tt.local)
+				}
+				got = requestUser(c)
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.auth)
+			}
+			if _, err := app.Test(req, -1); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("requestUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// respErrorTag() returns the error-tag of the first error in the response.
+func respErrorTag(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var body map[string]map[string][]map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	for _, errors := range body {
+		if len(errors["error"]) > 0 {
+			tag, _ := errors["error"][0]["error-tag"].(string)
+			return tag
+		}
+	}
+	return ""
+}
+
+func Test_Action(t *testing.T) {
+	const data = `{"example-actions:interfaces":{"interface":[{"name":"eth0"},{"name":"eth1"}]}}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		tag    ErrorTag // the error-tag of the failure
+		target string   // the name of the target interface passed to the handler
+	}{
+		{name: "reset", path: "/example-actions:interfaces/interface=eth1/reset",
+			body: `{"example-actions:input":{"delay":5}}`, status: fiber.StatusNoContent, target: "eth1"},
+		{name: "unknown action", path: "/example-actions:interfaces/interface=eth1/shutdown",
+			status: fiber.StatusBadRequest, tag: ETagUnknownElement},
+		{name: "unregistered action", path: "/example-actions:interfaces/interface=eth1/get-last-reset-time",
+			status: fiber.StatusNotImplemented, tag: ETagOperationNotSupported},
+		{name: "missing target", path: "/example-actions:interfaces/interface=eth9/reset",
+			body: `{"example-actions:input":{"delay":5}}`, status: fiber.StatusNotFound, tag: ETagDataMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newRESTCtrl(t, "modules/example/example-actions.yang", data)
+			var target, tpath, delay string
+			err := rc.RegisterAction("/example-actions:interfaces/interface/reset", func(req *RPCRequest) (yangtree.DataNode, error) {
+				target, tpath = req.Target.GetValueString("name"), req.TargetPath
+				delay = req.Input.GetValueString("delay")
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := doRequest(t, app, "POST", "/restconf/data"+tt.path, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode >= fiber.StatusBadRequest {
+				if tag := respErrorTag(t, resp); tag != tt.tag.String() {
+					t.Errorf("error-tag = %s, want %s", tag, tt.tag)
+				}
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+			if tt.target == "" {
+				return
+			}
+			if want := "interfaces/interface[name=" + tt.target + "]"; tpath != want {
+				t.Errorf("target path = %q, want %q", tpath, want)
+			}
+			if delay != "5" {
+				t.Errorf("delay = %q, want 5", delay)
+			}
+		})
+	}
+}
+
+func Test_BasicAuth(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-ops.yang"}, *dir, *excludes)
+	if _, err := rc.BasicAuth([]string{"admin"}); err == nil {
+		t.Errorf("BasicAuth() accepted the user without password")
+	}
+	auth, err := rc.BasicAuth([]string{"admin:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+		want   string
+	}{
+		{name: "verified", auth: "admin:secret", status: fiber.StatusOK, want: "admin"},
+		{name: "wrong password", auth: "admin:wrong", status: fiber.StatusUnauthorized},
+		{name: "anonymous", status: fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+			app.Use(auth)
+			app.Get("/", func(c *fiber.Ctx) error {
+				got = requestUser(c)
+				return nil
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.auth)))
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got != tt.want {
+				t.Errorf("requestUser() = %q, want %q", got, tt.want)
+			}
+			if tt.status == fiber.StatusUnauthorized && resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+				t.Errorf("WWW-Authenticate not present")
+			}
+		})
+	}
+}
+
+func Test_Invoke(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		handler RPCHandler // nil if not registered
+		status  int
+		tag     ErrorTag // the error-tag of the failure
+	}{
+		{name: "unregistered", path: "/example-ops:reboot", status: fiber.StatusNotImplemented,
+			tag: ETagOperationNotSupported},
+		{name: "output", path: "/example-ops:get-reboot-info", status: fiber.StatusOK,
+			handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+				output, err := yangtree.New(req.Schema.GetSchema("output"))
+				if err != nil {
+					return nil, err
+				}
+				return output, yangtree.SetValue(output, "reboot-time", nil, "10")
+			}},
+		{name: "output of another schema", path: "/example-ops:get-reboot-info",
+			status: fiber.StatusInternalServerError, tag: ETagOperationFailed,
+			handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+				return yangtree.New(req.Data.rc.schemaData)
+			}},
+		{name: "output of no output rpc", path: "/example-ops:reboot", body: `{"example-ops:input":{}}`,
+			status: fiber.StatusInternalServerError, tag: ETagOperationFailed,
+			handler: func(req *RPCRequest) (yangtree.DataNode, error) {
+				return yangtree.New(req.Schema.GetSchema("input"))
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newRESTCtrl(t, "modules/example/example-ops.yang", "")
+			if tt.handler != nil {
+				if err := rc.RegisterRPC(tt.path, tt.handler); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp := doRequest(t, app, "POST", "/restconf/operations"+tt.path, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("POST status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.StatusCode >= fiber.StatusBadRequest {
+				if tag := respErrorTag(t, resp); tag != tt.tag.String() {
+					t.Errorf("error-tag = %s, want %s", tag, tt.tag)
+				}
+			}
+		})
+	}
+}
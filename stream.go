@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// RFC8040 6. Notifications
+
+// Notification is an event notification delivered to the stream subscribers.
+type Notification struct {
+	EventTime time.Time
+	Node      yangtree.DataNode // the notification data node
+}
+
+// EventLog is the notification log of a stream kept to replay the
+// notifications for the "start-time" and "stop-time" query parameters.
+type EventLog struct {
+	sync.RWMutex
+	events []*Notification
+	size   int
+}
+
+// NewEventLog() returns a notification log that keeps the last size notifications.
+func NewEventLog(size int) *EventLog {
+	return &EventLog{size: size}
+}
+
+// Add() appends the notification to the log. The oldest notification
+// is dropped if the log is full.
+func (el *EventLog) Add(n *Notification) {
+	el.Lock()
+	defer el.Unlock()
+	if el.size <= 0 {
+		return
+	}
+	if len(el.events) >= el.size {
+		copy(el.events, el.events[1:])
+		el.events = el.events[:len(el.events)-1]
+	}
+	el.events = append(el.events, n)
+}
+
+// Oldest() returns the event time of the oldest notification in the log.
+func (el *EventLog) Oldest() (time.Time, bool) {
+	el.RLock()
+	defer el.RUnlock()
+	if len(el.events) == 0 {
+		return time.Time{}, false
+	}
+	return el.events[0].EventTime, true
+}
+
+// Replay() returns the notifications logged between the start and stop time.
+// The zero stop time means no end of the replay.
+func (el *EventLog) Replay(start, stop time.Time) []*Notification {
+	el.RLock()
+	defer el.RUnlock()
+	var events []*Notification
+	for _, n := range el.events {
+		if n.EventTime.Before(start) {
+			continue
+		}
+		if !stop.IsZero() && n.EventTime.After(stop) {
+			break
+		}
+		events = append(events, n)
+	}
+	return events
+}
+
+// StreamQuery is the query parameters of the stream subscription.
+type StreamQuery struct {
+	Filter    string    // "filter" parameter: XPath expression
+	StartTime time.Time // "start-time" parameter: zero if not present
+	StopTime  time.Time // "stop-time" parameter: zero if not present
+}
+
+// ParseStreamQuery() parses and validates the "filter", "start-time" and
+// "stop-time" query parameters (RFC8040 4.8.4, 4.8.7, 4.8.8) of the
+// stream subscription. replay is true if the stream supports the replay.
+func (rc *RESTCtrl) ParseStreamQuery(c *fiber.Ctx, replay bool) (*StreamQuery, error) {
+	q := &StreamQuery{Filter: c.Query("filter")}
+	if c.Request().URI().QueryArgs().Has("filter") {
+		if err := rc.compileFilter(q.Filter); err != nil {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid filter parameter: %v", err))
+		}
+	}
+	if start := c.Query("start-time"); start != "" {
+		if !replay {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagOperationNotSupported,
+				c.Path(), "replay not supported by the stream")
+		}
+		t, err := time.Parse(time.RFC3339Nano, start)
+		if err != nil {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid start-time parameter: %v", err))
+		}
+		if t.After(time.Now()) {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "start-time must not be in the future")
+		}
+		q.StartTime = t
+	}
+	if stop := c.Query("stop-time"); stop != "" {
+		if q.StartTime.IsZero() {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "stop-time must be used with start-time")
+		}
+		t, err := time.Parse(time.RFC3339Nano, stop)
+		if err != nil {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), fmt.Sprintf("invalid stop-time parameter: %v", err))
+		}
+		if t.Before(q.StartTime) {
+			return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+				c.Path(), "stop-time must not be earlier than start-time")
+		}
+		q.StopTime = t
+	}
+	return q, nil
+}
+
+// compileFilter() checks the XPath filter is able to be evaluated by
+// evaluating it once on an empty datastore, so that the subscription of
+// an invalid filter is rejected instead of dropping all notifications.
+func (rc *RESTCtrl) compileFilter(filter string) error {
+	if err := validateFilter(filter); err != nil {
+		return err
+	}
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		return err
+	}
+	_, err = yangtree.Find(root, filter)
+	return err
+}
+
+// validateFilter() checks the brackets and quotes of the XPath filter are balanced.
+func validateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty filter")
+	}
+	var stack []byte
+	var quote byte
+	for i := 0; i < len(filter); i++ {
+		ch := filter[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '[' || ch == '(':
+			stack = append(stack, ch)
+		case ch == ']' || ch == ')':
+			open := byte('[')
+			if ch == ')' {
+				open = '('
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return fmt.Errorf("unbalanced %q at %d", ch, i)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated quote")
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("unbalanced %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// Match() returns true if the notification needs to be delivered to the
+// subscriber of the StreamQuery. The notification is matched if the filter
+// selects any node of the notification and the event time is within
+// the replay window.
+func (q *StreamQuery) Match(n *Notification) bool {
+	if !q.StopTime.IsZero() && n.EventTime.After(q.StopTime) {
+		return false
+	}
+	if q.Filter == "" {
+		return true
+	}
+	found, err := yangtree.Find(n.Node, q.Filter)
+	return err == nil && len(found) > 0
+}
+
+// Done() returns true if the subscription is completed by the stop-time.
+func (q *StreamQuery) Done(now time.Time) bool {
+	return !q.StopTime.IsZero() && now.After(q.StopTime)
+}
+
+// DefaultStream is the name of the default event stream (RFC8040 6.2)
+// that carries all notifications.
+const DefaultStream = "NETCONF"
+
+// subscriberQueue is the number of the notifications queued to a subscriber.
+// The subscriber is dropped if the queue is overflowed.
+const subscriberQueue = 64
+
+// keepAliveInterval is the interval of the SSE comments sent to detect
+// the closed connection of the subscriber.
+const keepAliveInterval = 30 * time.Second
+
+// Stream is the notification event stream delivered to the subscribers
+// over Server-Sent Events. (RFC8040 6.3)
+type Stream struct {
+	Name        string
+	mutex       sync.Mutex
+	log         *EventLog // the replay log: nil if the replay is not supported
+	subscribers map[chan *Notification]struct{}
+}
+
+// NewStream() returns the event stream that keeps the last replay
+// notifications for the replay. The replay is not supported if zero.
+func NewStream(name string, replay int) *Stream {
+	s := &Stream{Name: name, subscribers: map[chan *Notification]struct{}{}}
+	if replay > 0 {
+		s.log = NewEventLog(replay)
+	}
+	return s
+}
+
+// Send() logs the notification and delivers it to all subscribers.
+func (s *Stream) Send(n *Notification) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.log != nil {
+		s.log.Add(n)
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- n:
+		default:
+			log.Printf("restconf: subscriber of stream %s dropped by queue overflow", s.Name)
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe() returns the channel of the notifications sent to the stream
+// and the notifications in the replay log for the start and stop time.
+func (s *Stream) Subscribe(start, stop time.Time) (chan *Notification, []*Notification) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var replay []*Notification
+	if s.log != nil && !start.IsZero() {
+		replay = s.log.Replay(start, stop)
+	}
+	ch := make(chan *Notification, subscriberQueue)
+	s.subscribers[ch] = struct{}{}
+	return ch, replay
+}
+
+// Unsubscribe() removes the subscriber channel from the stream.
+func (s *Stream) Unsubscribe(ch chan *Notification) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// streamLocation() returns the location of the stream for the encoding.
+func streamLocation(name, encoding string) string {
+	return "/streams/" + url.PathEscape(name) + "/" + encoding
+}
+
+// AddStream() registers the event stream and adds it to the
+// restconf-state/streams list (RFC8040 9.3). The stream keeps the last
+// replay notifications for the "start-time" query parameter.
+func (rc *RESTCtrl) AddStream(name, description string, replay int) (*Stream, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	if _, ok := rc.streams[name]; ok {
+		return nil, fmt.Errorf("restconf: stream %s already exists", name)
+	}
+	qname, err := xpathValue(name)
+	if err != nil {
+		return nil, fmt.Errorf("restconf: invalid stream name: %v", err)
+	}
+	s := NewStream(name, replay)
+	xpath := fmt.Sprintf("restconf-state/streams/stream[name=%s]", qname)
+	values := map[string]string{
+		"replay-support":                 strconv.FormatBool(s.log != nil),
+		"access[encoding=xml]/location":  streamLocation(name, "xml"),
+		"access[encoding=json]/location": streamLocation(name, "json"),
+	}
+	if description != "" {
+		values["description"] = description
+	}
+	if s.log != nil {
+		values["replay-log-creation-time"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	for leaf, value := range values {
+		if err := yangtree.SetValue(rc.DataRoot, xpath+"/"+leaf, nil, value); err != nil {
+			return nil, fmt.Errorf("restconf: unable to add stream %s: %v", name, err)
+		}
+	}
+	rc.revisions.Touch(xpath)
+	rc.streams[name] = s
+	return s, nil
+}
+
+// Notify() sends the notification data node to the subscribers of the
+// stream and the default stream. The node must be the data node of
+// a notification schema.
+func (rc *RESTCtrl) Notify(name string, node yangtree.DataNode) error {
+	if node == nil || node.Schema().Kind != yang.NotificationEntry {
+		return fmt.Errorf("restconf: not a notification data node")
+	}
+	rc.RLock()
+	s, ok := rc.streams[name]
+	dflt := rc.streams[DefaultStream]
+	rc.RUnlock()
+	if !ok {
+		return fmt.Errorf("restconf: stream %s not found", name)
+	}
+	// The notification is inserted to a new data root to evaluate
+	// the absolute path of the filter.
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		return fmt.Errorf("restconf: %v", err)
+	}
+	n := yangtree.Clone(node)
+	if _, err := root.Insert(n, nil); err != nil {
+		return fmt.Errorf("restconf: unable to send notification: %v", err)
+	}
+	event := &Notification{EventTime: time.Now().UTC(), Node: n}
+	s.Send(event)
+	if dflt != nil && dflt != s {
+		dflt.Send(event)
+	}
+	return nil
+}
+
+// EncodeEvent() encodes the notification to the event of the
+// text/event-stream. (RFC8040 6.4)
+func EncodeEvent(n *Notification, encoding string) ([]byte, error) {
+	var b bytes.Buffer
+	eventTime := n.EventTime.Format(time.RFC3339Nano)
+	switch encoding {
+	case "json":
+		event, err := yangtree.MarshalJSON(n.Node, yangtree.RepresentItself{})
+		if err != nil {
+			return nil, err
+		}
+		event = bytes.TrimSpace(event)
+		if len(event) < 2 {
+			return nil, fmt.Errorf("invalid notification %s", n.Node.Name())
+		}
+		fmt.Fprintf(&b, `{"ietf-restconf:notification":{"eventTime":%q,%s}}`,
+			eventTime, event[1:len(event)-1])
+	default:
+		event, err := yangtree.MarshalXMLIndent(n.Node, "  ", "  ", yangtree.RepresentItself{})
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(`<notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0">` + "\n")
+		fmt.Fprintf(&b, "  <eventTime>%s</eventTime>\n", eventTime)
+		b.WriteString("  ")
+		b.Write(bytes.TrimSpace(event))
+		b.WriteString("\n</notification>")
+	}
+	return eventData(b.Bytes()), nil
+}
+
+// eventData() returns the event of the text/event-stream that has
+// a "data:" field for each line of the message.
+func eventData(msg []byte) []byte {
+	var b bytes.Buffer
+	for _, line := range bytes.Split(msg, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// InstallRouteStreams() serves the event streams at
+// /streams/<stream-name>/<encoding>. (RFC8040 6.3)
+func InstallRouteStreams(app *fiber.App, rc *RESTCtrl) error {
+	app.All("/streams/:name/:encoding", func(c *fiber.Ctx) error {
+		name, _ := url.PathUnescape(c.Params("name"))
+		encoding := c.Params("encoding")
+		rc.RLock()
+		s, ok := rc.streams[name]
+		rc.RUnlock()
+		if !ok || (encoding != "xml" && encoding != "json") {
+			return NewError(rc, fiber.StatusNotFound, ETypeApplication,
+				ETagDataMissing, c.Path(), "stream not found")
+		}
+		switch c.Method() {
+		case "GET", "HEAD":
+		case "OPTIONS":
+			return rc.ResponseOptions(c, "OPTIONS", "HEAD", "GET")
+		default:
+			return NewError(rc, fiber.StatusMethodNotAllowed, ETypeTransport,
+				ETagAccessDenied, c.Path(), "HTTP GET and HEAD only allowed for the stream")
+		}
+		if c.Accepts("text/event-stream") == "" {
+			return NewError(rc, fiber.StatusNotAcceptable, ETypeTransport,
+				ETagInvalidValue, c.Path(), "stream only available in text/event-stream")
+		}
+		q, err := rc.ParseStreamQuery(c, s.log != nil)
+		if err != nil {
+			return err
+		}
+		c.Set("Server", "open-restconf")
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		if c.Method() == "HEAD" {
+			// HEAD returns the header fields of GET without subscribing.
+			return nil
+		}
+		ch, replay := s.Subscribe(q.StartTime, q.StopTime)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer s.Unsubscribe(ch)
+			write := func(n *Notification) error {
+				if !q.Match(n) {
+					return nil
+				}
+				b, err := EncodeEvent(n, encoding)
+				if err != nil {
+					log.Printf("restconf: unable to encode notification: %v", err)
+					return nil
+				}
+				if _, err := w.Write(b); err != nil {
+					return err
+				}
+				return w.Flush()
+			}
+			for _, n := range replay {
+				if write(n) != nil {
+					return
+				}
+			}
+			var stop <-chan time.Time
+			if !q.StopTime.IsZero() {
+				if q.Done(time.Now()) {
+					return
+				}
+				timer := time.NewTimer(time.Until(q.StopTime))
+				defer timer.Stop()
+				stop = timer.C
+			}
+			ticker := time.NewTicker(keepAliveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case n, ok := <-ch:
+					if !ok || write(n) != nil {
+						return
+					}
+				case <-stop:
+					return
+				case <-ticker.C:
+					if _, err := w.WriteString(":\n\n"); err != nil || w.Flush() != nil {
+						return
+					}
+				}
+			}
+		})
+		return nil
+	})
+	return nil
+}
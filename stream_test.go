@@ -0,0 +1,264 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_EventLog(t *testing.T) {
+	base := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	el := NewEventLog(3)
+	for i := 0; i < 5; i++ {
+		el.Add(&Notification{EventTime: base.Add(time.Duration(i) * time.Minute)})
+	}
+	if oldest, ok := el.Oldest(); !ok || !oldest.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("Oldest() = %v, want %v", oldest, base.Add(2*time.Minute))
+	}
+	tests := []struct {
+		name  string
+		start time.Time
+		stop  time.Time
+		want  int
+	}{
+		{name: "all", start: base, want: 3},
+		{name: "from 3m", start: base.Add(3 * time.Minute), want: 2},
+		{name: "3m to 3m", start: base.Add(3 * time.Minute), stop: base.Add(3 * time.Minute), want: 1},
+		{name: "future", start: base.Add(time.Hour), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := el.Replay(tt.start, tt.stop); len(got) != tt.want {
+				t.Errorf("Replay() got %d notifications, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr bool
+	}{
+		{filter: "/event/event-class", wantErr: false},
+		{filter: "/event[event-class='fault']", wantErr: false},
+		{filter: "/event[contains(reporting-entity, ']')]", wantErr: false},
+		{filter: "", wantErr: true},
+		{filter: "/event[event-class='fault'", wantErr: true},
+		{filter: "/event[contains(x, 'y']", wantErr: true},
+		{filter: "/event[x='y]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			if err := validateFilter(tt.filter); (err != nil) != tt.wantErr {
+				t.Errorf("validateFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_eventData(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{msg: `{"ietf-restconf:notification":{}}`, want: "data: {\"ietf-restconf:notification\":{}}\n\n"},
+		{msg: "<notification>\n  <eventTime/>\n</notification>",
+			want: "data: <notification>\ndata:   <eventTime/>\ndata: </notification>\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := string(eventData([]byte(tt.msg))); got != tt.want {
+				t.Errorf("eventData() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Stream(t *testing.T) {
+	base := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStream(DefaultStream, 10)
+	s.Send(&Notification{EventTime: base})
+	s.Send(&Notification{EventTime: base.Add(time.Minute)})
+
+	ch, replay := s.Subscribe(base.Add(time.Minute), time.Time{})
+	if len(replay) != 1 {
+		t.Errorf("Subscribe() replayed %d notifications, want 1", len(replay))
+	}
+	live, _ := s.Subscribe(time.Time{}, time.Time{})
+	s.Send(&Notification{EventTime: base.Add(2 * time.Minute)})
+	for _, c := range []chan *Notification{ch, live} {
+		if n := <-c; !n.EventTime.Equal(base.Add(2 * time.Minute)) {
+			t.Errorf("received %v, want %v", n.EventTime, base.Add(2*time.Minute))
+		}
+	}
+	s.Unsubscribe(ch)
+	if _, ok := <-ch; ok {
+		t.Errorf("channel not closed by Unsubscribe()")
+	}
+
+	// the subscriber not reading the notifications is dropped.
+	for i := 0; i <= subscriberQueue; i++ {
+		s.Send(&Notification{EventTime: base.Add(3 * time.Minute)})
+	}
+	for range live {
+	}
+	if len(s.subscribers) != 0 {
+		t.Errorf("%d subscribers remain, want 0", len(s.subscribers))
+	}
+}
+
+func Test_Notify(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-mod.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	if _, err := rc.AddStream(DefaultStream, "default NETCONF event stream", 10); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := yangtree.Find(rc.DataRoot, "restconf-state/streams/stream[name=NETCONF]/access"); err != nil || len(found) != 2 {
+		t.Fatalf("stream access not populated: %v", err)
+	}
+	schema, err := findSchema(rc.schemaData, "/example-mod:event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := yangtree.NewWithValue(schema, map[interface{}]interface{}{"event-class": "fault"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := rc.streams[DefaultStream].Subscribe(time.Time{}, time.Time{})
+	if err := rc.Notify(DefaultStream, event); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if err := rc.Notify(DefaultStream, root); err == nil {
+		t.Errorf("Notify() accepted a non-notification node")
+	}
+	n := <-ch
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: "/event/event-class", want: true},
+		{filter: "/event[event-class='fault']", want: true},
+		{filter: "/event[event-class='info']", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q := &StreamQuery{Filter: tt.filter}
+			if got := q.Match(n); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for encoding, want := range map[string]string{
+		"json": `data: {"ietf-restconf:notification":{"eventTime":`,
+		"xml":  `data: <notification xmlns="urn:ietf:params:xml:ns:netconf:notification:1.0">`,
+	} {
+		b, err := EncodeEvent(n, encoding)
+		if err != nil {
+			t.Fatalf("EncodeEvent() error = %v", err)
+		}
+		if !strings.HasPrefix(string(b), want) || !strings.HasSuffix(string(b), "\n\n") {
+			t.Errorf("EncodeEvent() = %q", b)
+		}
+	}
+}
+
+func Test_ParseStreamQueryFilter(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-mod.yang"}, *dir, *excludes)
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	app.Get("/streams", func(c *fiber.Ctx) error {
+		_, err := rc.ParseStreamQuery(c, true)
+		return err
+	})
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{filter: "/event/event-class", want: fiber.StatusOK},
+		{filter: "/event[event-class='fault']", want: fiber.StatusOK},
+		{filter: "/event/unknown-leaf", want: fiber.StatusBadRequest},
+		{filter: "/event[event-class='fault'", want: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/streams?filter="+url.QueryEscape(tt.filter), nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func Test_StreamHead(t *testing.T) {
+	rc := loadSchema([]string{"modules/example/example-mod.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	if _, err := rc.AddStream(DefaultStream, "default NETCONF event stream", 10); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteStreams(app, rc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{method: "HEAD", path: "/streams/NETCONF/json", status: fiber.StatusOK},
+		{method: "HEAD", path: "/streams/NETCONF/xml", status: fiber.StatusOK},
+		{method: "HEAD", path: "/streams/unknown/json", status: fiber.StatusNotFound},
+		{method: "OPTIONS", path: "/streams/NETCONF/json", status: fiber.StatusOK, allow: "OPTIONS, HEAD, GET"},
+		{method: "DELETE", path: "/streams/NETCONF/json", status: fiber.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(fiber.HeaderAccept, "text/event-stream")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.allow != "" && resp.Header.Get("Allow") != tt.allow {
+				t.Errorf("Allow = %q, want %q", resp.Header.Get("Allow"), tt.allow)
+			}
+			if tt.method != "HEAD" || tt.status != fiber.StatusOK {
+				return
+			}
+			if ct := resp.Header.Get(fiber.HeaderContentType); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", ct)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != 0 {
+				t.Errorf("HEAD body = %s, want empty", body)
+			}
+		})
+	}
+	if n := len(rc.streams[DefaultStream].subscribers); n != 0 {
+		t.Errorf("%d subscribers after HEAD, want 0", n)
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// RFC7950 8. Constraints
+//
+// The input and output of the rpc and action are validated against the
+// constraints of the schema: mandatory nodes, range, length and pattern
+// restrictions, must and when statements. The must and when expressions are
+// evaluated by a subset of XPath 1.0: relative paths, current(), comparisons
+// with literals or paths, not(), count(), true(), false(), and and or
+// operators. The expression out of the subset and the pattern not supported by
+// Go regexp are reported as operation-failed rather than ignored.
+
+// Violation is a constraint violation found by the validation.
+type Violation struct {
+	Tag    ErrorTag
+	AppTag string // error-app-tag: empty if not specified
+	Path   string // the path of the violated node relative to the validated node
+	Msg    string
+	// Internal is true if the server is unable to check the constraint.
+	// It is reported as "500 Internal Server Error".
+	Internal bool
+}
+
+// Validate() validates the node and its descendants against the schema
+// constraints and returns all violations found. The path of the violation
+// starts with the path segment of the node.
+func Validate(node yangtree.DataNode) []*Violation {
+	return validate(node, "", nil)
+}
+
+// violationError() returns the RespError reporting all violations. The
+// violations of the output are reported as operation-failed if failed is true,
+// and the code is "500 Internal Server Error" if any constraint is not checked.
+func (rc *RESTCtrl) violationError(code int, epath string, violations []*Violation, failed bool) *RespError {
+	var re *RespError
+	for _, v := range violations {
+		if v.Internal {
+			code = fiber.StatusInternalServerError
+		}
+	}
+	for _, v := range violations {
+		etag := v.Tag
+		if failed {
+			etag = ETagOperationFailed
+		}
+		re = re.Add(rc, code, ETypeApplication, etag, epath+v.Path, v.Msg).SetAppTag(v.AppTag)
+	}
+	return re
+}
+
+func validate(node yangtree.DataNode, path string, violations []*Violation) []*Violation {
+	schema := node.Schema()
+	path = path + "/" + nodeSegment(node).String()
+	violations = checkWhenMust(node, path, violations)
+	if !node.IsBranchNode() {
+		if v := checkValue(schema, node.ValueString(), path); v != nil {
+			violations = append(violations, v)
+		}
+		return violations
+	}
+	violations = checkMandatory(node, schema, path, violations)
+	for _, child := range node.Children() {
+		violations = validate(child, path, violations)
+	}
+	return violations
+}
+
+// checkMandatory() checks the mandatory nodes (RFC7950 3) of the schema
+// exist in the node: the mandatory leafs and choices, the lists and
+// leaf-lists of min-elements and the mandatory nodes in the non-presence
+// containers and in the case of the choice. The node is nil if the
+// non-presence container is absent. The nodes conditioned by when
+// statements are not checked.
+func checkMandatory(node yangtree.DataNode, schema *yangtree.SchemaNode, path string, violations []*Violation) []*Violation {
+	missing := func(cschema *yangtree.SchemaNode, msg string) {
+		violations = append(violations, &Violation{Tag: ETagMissingElement,
+			Path: path + "/" + cschema.Name, Msg: msg})
+	}
+	for _, cschema := range schema.Children {
+		if when, _ := whenMust(cschema); when != "" {
+			continue
+		}
+		switch {
+		case cschema.IsChoice():
+			var selected *yangtree.SchemaNode
+			for _, c := range cschema.Children {
+				if hasCaseData(node, c) {
+					selected = c
+					break
+				}
+			}
+			switch {
+			case selected == nil && cschema.Mandatory.Value():
+				missing(cschema, fmt.Sprintf("mandatory choice %s is missing", cschema.Name))
+			case selected != nil && selected.IsCase():
+				// The shorthand case node is checked by the validation of the node.
+				violations = checkMandatory(node, selected, path, violations)
+			}
+		case cschema.IsList() || cschema.IsLeafList():
+			if min := minElements(cschema); countChildren(node, cschema.Name) < min {
+				missing(cschema, fmt.Sprintf("%s requires at least %d entries", cschema.Name, min))
+			}
+		case cschema.IsLeaf():
+			if cschema.Mandatory.Value() && (node == nil || !node.Exist(cschema.Name)) {
+				missing(cschema, fmt.Sprintf("mandatory %s is missing", cschema.Name))
+			}
+		case cschema.IsDir() && !isPresence(cschema):
+			// The existing container is checked by the validation of the node.
+			if node == nil || !node.Exist(cschema.Name) {
+				violations = checkMandatory(nil, cschema, path+"/"+cschema.Name, violations)
+			}
+		}
+	}
+	return violations
+}
+
+// hasCaseData() returns true if any data node of the case of a choice
+// exists in the node. The schema is the case or the shorthand case node.
+func hasCaseData(node yangtree.DataNode, schema *yangtree.SchemaNode) bool {
+	if node == nil {
+		return false
+	}
+	if !schema.IsChoice() && !schema.IsCase() {
+		return node.Exist(schema.Name)
+	}
+	for _, cschema := range schema.Children {
+		if hasCaseData(node, cschema) {
+			return true
+		}
+	}
+	return false
+}
+
+// countChildren() returns the number of the child nodes of the name.
+func countChildren(node yangtree.DataNode, name string) uint64 {
+	if node == nil {
+		return 0
+	}
+	found, err := yangtree.Find(node, name)
+	if err != nil {
+		return 0
+	}
+	return uint64(len(found))
+}
+
+// minElements() returns the min-elements of the list or leaf-list schema.
+func minElements(schema *yangtree.SchemaNode) uint64 {
+	var min *yang.Value
+	switch n := schema.Node.(type) {
+	case *yang.List:
+		min = n.MinElements
+	case *yang.LeafList:
+		min = n.MinElements
+	}
+	if min == nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(min.Name, 10, 64)
+	return v
+}
+
+// isPresence() returns true if the schema is a presence container.
+func isPresence(schema *yangtree.SchemaNode) bool {
+	n, ok := schema.Node.(*yang.Container)
+	return ok && n.Presence != nil
+}
+
+// whenMust() returns the when and must statements of the schema node.
+func whenMust(schema *yangtree.SchemaNode) (string, []*yang.Must) {
+	var when *yang.Value
+	var must []*yang.Must
+	switch n := schema.Node.(type) {
+	case *yang.Leaf:
+		when, must = n.When, n.Must
+	case *yang.LeafList:
+		when, must = n.When, n.Must
+	case *yang.Container:
+		when, must = n.When, n.Must
+	case *yang.List:
+		when, must = n.When, n.Must
+	}
+	if when == nil {
+		return "", must
+	}
+	return when.Name, must
+}
+
+// unevaluated() returns the violation of the when or must expression that
+// is not able to be evaluated by the XPath subset. The constraint is not
+// regarded as satisfied.
+func unevaluated(stmt, expr, path string, err error) *Violation {
+	return &Violation{Tag: ETagOperationFailed, Path: path, Internal: true,
+		Msg: fmt.Sprintf("unable to evaluate %s condition %q: %v", stmt, expr, err)}
+}
+
+// checkWhenMust() evaluates the when and must statements of the node.
+func checkWhenMust(node yangtree.DataNode, path string, violations []*Violation) []*Violation {
+	when, must := whenMust(node.Schema())
+	if when != "" {
+		ok, err := evalXPath(node, when)
+		if err != nil {
+			violations = append(violations, unevaluated("when", when, path, err))
+		} else if !ok {
+			violations = append(violations, &Violation{Tag: ETagUnknownElement, Path: path,
+				Msg: fmt.Sprintf("when condition %q is not satisfied", when)})
+		}
+	}
+	for _, m := range must {
+		ok, err := evalXPath(node, m.Name)
+		if err != nil {
+			violations = append(violations, unevaluated("must", m.Name, path, err))
+			continue
+		}
+		if ok {
+			continue
+		}
+		v := &Violation{Tag: ETagOperationFailed, AppTag: "must-violation", Path: path,
+			Msg: fmt.Sprintf("must condition %q is not satisfied", m.Name)}
+		if m.ErrorAppTag != nil {
+			v.AppTag = m.ErrorAppTag.Name
+		}
+		if m.ErrorMessage != nil {
+			v.Msg = m.ErrorMessage.Name
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// checkValue() checks the value against the range, length and pattern
+// restrictions of the leaf type. It returns the violation if the value is
+// not valid or the pattern is not able to be evaluated.
+func checkValue(schema *yangtree.SchemaNode, value, path string) *Violation {
+	invalid := func(format string, a ...interface{}) *Violation {
+		return &Violation{Tag: ETagInvalidValue, Path: path, Msg: fmt.Sprintf(format, a...)}
+	}
+	typ := schema.Type
+	if typ == nil {
+		return nil
+	}
+	switch typ.Kind {
+	case yang.Yint8, yang.Yint16, yang.Yint32, yang.Yint64,
+		yang.Yuint8, yang.Yuint16, yang.Yuint32, yang.Yuint64:
+		n, err := yang.ParseInt(value)
+		if err != nil {
+			return invalid("invalid %s value %q", typ.Name, value)
+		}
+		if len(typ.Range) > 0 && !typ.Range.Contains(yang.YangRange{{Min: n, Max: n}}) {
+			return invalid("%s is out of range %s", value, typ.Range)
+		}
+	case yang.Ydecimal64:
+		n, err := yang.ParseDecimal(value, uint8(typ.FractionDigits))
+		if err != nil {
+			return invalid("invalid %s value %q", typ.Name, value)
+		}
+		if len(typ.Range) > 0 && !typ.Range.Contains(yang.YangRange{{Min: n, Max: n}}) {
+			return invalid("%s is out of range %s", value, typ.Range)
+		}
+	case yang.Ystring, yang.Ybinary:
+		if len(typ.Length) > 0 {
+			n := yang.FromInt(int64(utf8.RuneCountInString(value)))
+			if !typ.Length.Contains(yang.YangRange{{Min: n, Max: n}}) {
+				return invalid("the length of %q is out of %s", value, typ.Length)
+			}
+		}
+		for _, pattern := range typ.Pattern {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				// The XSD regular expression not supported by Go is not
+				// regarded as matched.
+				return &Violation{Tag: ETagOperationFailed, Path: path, Internal: true,
+					Msg: fmt.Sprintf("unable to evaluate pattern %q: %v", pattern, err)}
+			}
+			if !re.MatchString(value) {
+				return invalid("%q does not match the pattern %q", value, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// splitTopLevel() splits the XPath expression by the operator that is not
+// enclosed in quotes, brackets or parentheses.
+func splitTopLevel(expr, op string) []string {
+	var result []string
+	var depth int
+	var quote byte
+	begin := 0
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '[' || ch == '(':
+			depth++
+		case ch == ']' || ch == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(expr[i:], op):
+			result = append(result, expr[begin:i])
+			i += len(op) - 1
+			begin = i + 1
+		}
+	}
+	return append(result, expr[begin:])
+}
+
+// isEnclosed() returns true if the whole expression is enclosed in a pair of parentheses.
+func isEnclosed(expr string) bool {
+	if !strings.HasPrefix(expr, "(") || !strings.HasSuffix(expr, ")") {
+		return false
+	}
+	var depth int
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 && i < len(expr)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// evalXPath() evaluates the XPath expression of the when or must statement
+// as a boolean on the context node.
+func evalXPath(node yangtree.DataNode, expr string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if terms := splitTopLevel(expr, " or "); len(terms) > 1 {
+		for _, term := range terms {
+			ok, err := evalXPath(node, term)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if terms := splitTopLevel(expr, " and "); len(terms) > 1 {
+		for _, term := range terms {
+			ok, err := evalXPath(node, term)
+			if err != nil || !ok {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	switch {
+	case expr == "true()":
+		return true, nil
+	case expr == "false()":
+		return false, nil
+	case strings.HasPrefix(expr, "not(") && isEnclosed(expr[len("not"):]):
+		ok, err := evalXPath(node, expr[len("not("):len(expr)-1])
+		return !ok, err
+	case isEnclosed(expr):
+		return evalXPath(node, expr[1:len(expr)-1])
+	}
+	for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if operands := splitTopLevel(expr, op); len(operands) == 2 {
+			lhs, err := evalOperand(node, operands[0])
+			if err != nil {
+				return false, err
+			}
+			rhs, err := evalOperand(node, operands[1])
+			if err != nil {
+				return false, err
+			}
+			for i := range lhs {
+				for j := range rhs {
+					if compare(lhs[i], rhs[j], op) {
+						return true, nil
+					}
+				}
+			}
+			return false, nil
+		}
+	}
+	values, err := evalOperand(node, expr)
+	return len(values) > 0, err
+}
+
+// evalOperand() returns the values of the operand of the XPath comparison.
+func evalOperand(node yangtree.DataNode, operand string) ([]string, error) {
+	operand = strings.TrimSpace(operand)
+	switch {
+	case operand == "":
+		return nil, fmt.Errorf("empty operand")
+	case operand[0] == '\'' || operand[0] == '"':
+		if len(operand) < 2 || operand[len(operand)-1] != operand[0] {
+			return nil, fmt.Errorf("unterminated literal %s", operand)
+		}
+		return []string{operand[1 : len(operand)-1]}, nil
+	case operand[0] >= '0' && operand[0] <= '9' || operand[0] == '-':
+		if _, err := strconv.ParseFloat(operand, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %s", operand)
+		}
+		return []string{operand}, nil
+	case strings.HasPrefix(operand, "count(") && strings.HasSuffix(operand, ")"):
+		found, err := findXPath(node, operand[len("count("):len(operand)-1])
+		if err != nil {
+			return nil, err
+		}
+		return []string{strconv.Itoa(len(found))}, nil
+	case strings.HasPrefix(operand, "/"):
+		return nil, fmt.Errorf("absolute path %s not supported", operand)
+	case strings.HasPrefix(operand, "current()"):
+		// current() is the context node out of the predicates.
+		if rest := operand[len("current()"):]; rest != "" &&
+			(!strings.HasPrefix(rest, "/") || strings.ContainsAny(rest, "()+*|")) {
+			return nil, fmt.Errorf("unsupported expression %s", operand)
+		}
+	case strings.ContainsAny(operand, "()+*|"):
+		return nil, fmt.Errorf("unsupported expression %s", operand)
+	}
+	found, err := findXPath(node, operand)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(found))
+	for i := range found {
+		values = append(values, found[i].ValueString())
+	}
+	return values, nil
+}
+
+// findXPath() returns the nodes of the relative path from the context node.
+func findXPath(node yangtree.DataNode, path string) ([]yangtree.DataNode, error) {
+	path = strings.TrimSpace(path)
+	if path == "." || path == "current()" {
+		return []yangtree.DataNode{node}, nil
+	}
+	path = strings.TrimPrefix(path, "current()/")
+	return yangtree.Find(node, path)
+}
+
+// compare() compares the values by the operator. The values are compared
+// as numbers if both are numbers.
+func compare(a, b, op string) bool {
+	x, errx := strconv.ParseFloat(a, 64)
+	y, erry := strconv.ParseFloat(b, 64)
+	if errx == nil && erry == nil {
+		switch op {
+		case "=":
+			return x == y
+		case "!=":
+			return x != y
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+		return false
+	}
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
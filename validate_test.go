@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_splitTopLevel(t *testing.T) {
+	tests := []struct {
+		expr string
+		op   string
+		want []string
+	}{
+		{expr: "a = 'b'", op: "=", want: []string{"a ", " 'b'"}},
+		{expr: "a[x='1 and 2'] and b", op: " and ", want: []string{"a[x='1 and 2']", "b"}},
+		{expr: "not(a or b) or c", op: " or ", want: []string{"not(a or b)", "c"}},
+		{expr: "../delay", op: "=", want: []string{"../delay"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if got := splitTopLevel(tt.expr, tt.op); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitTopLevel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isEnclosed(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "(a = 'b')", want: true},
+		{expr: "((a) and (b))", want: true},
+		{expr: "(a) and (b)", want: false},
+		{expr: "(a = ')')", want: true},
+		{expr: "a", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if got := isEnclosed(tt.expr); got != tt.want {
+				t.Errorf("isEnclosed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compare(t *testing.T) {
+	tests := []struct {
+		a, b, op string
+		want     bool
+	}{
+		{a: "10", b: "9", op: ">", want: true},
+		{a: "10", b: "9.0", op: "<=", want: false},
+		{a: "1.0", b: "1", op: "=", want: true},
+		{a: "en", b: "en", op: "=", want: true},
+		{a: "en", b: "ko", op: "!=", want: true},
+		{a: "en", b: "ko", op: "<", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+tt.op+tt.b, func(t *testing.T) {
+			if got := compare(tt.a, tt.b, tt.op); got != tt.want {
+				t.Errorf("compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newInput() returns the rpc node of the schema path with the input
+// encoded in RFC7951 JSON.
+func newInput(t *testing.T, rc *RESTCtrl, path, input string) yangtree.DataNode {
+	schema, err := findSchema(rc.schemaOperations, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc, err := yangtree.New(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yangtree.UnmarshalJSON(rpc, []byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	return rpc.Get("input")
+}
+
+func Test_ValidateUnevaluated(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-validate.yang"}, *dir, *excludes)
+	tests := []struct {
+		name  string
+		input string
+		path  string // the path suffix of the unevaluated node
+	}{
+		{name: "must", input: `{"name":"a","note":"hello"}`, path: "/note"},
+		{name: "pattern", input: `{"name":"a","code":"hello"}`, path: "/code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := newInput(t, rc, "/example-validate:configure", `{"example-validate:input":`+tt.input+`}`)
+			violations := Validate(input)
+			if len(violations) != 1 {
+				t.Fatalf("Validate() = %d violations, want 1", len(violations))
+			}
+			if v := violations[0]; v.Tag != ETagOperationFailed || !v.Internal || !strings.HasSuffix(v.Path, tt.path) {
+				t.Errorf("Validate() = %+v, want the internal operation-failed of %s", v, tt.path)
+			}
+			re := rc.violationError(fiber.StatusBadRequest, "/restconf/operations", violations, false)
+			if re.Code != fiber.StatusInternalServerError {
+				t.Errorf("violationError() code = %d, want %d", re.Code, fiber.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+func Test_ValidateMandatory(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-validate.yang"}, *dir, *excludes)
+	tests := []struct {
+		name  string
+		input string
+		want  []string // the path suffixes of the missing nodes
+	}{
+		{name: "valid", input: `{"target":{"address":"a"},"port":80,"tls":{"cert":"c"},"server":["s"]}`},
+		{name: "shorthand case", input: `{"target":{"address":"a"},"udp-port":53,"server":["s"]}`},
+		{name: "absent non-presence container", input: `{"udp-port":53,"server":["s"]}`,
+			want: []string{"/target/address"}},
+		{name: "present presence container", input: `{"target":{"address":"a"},"udp-port":53,"server":["s"],"options":{}}`,
+			want: []string{"/options/mode"}},
+		{name: "mandatory choice", input: `{"target":{"address":"a"},"server":["s"]}`,
+			want: []string{"/transport"}},
+		{name: "mandatory in case", input: `{"target":{"address":"a"},"port":80,"server":["s"]}`,
+			want: []string{"/tls/cert"}},
+		{name: "min-elements", input: `{"target":{"address":"a"},"udp-port":53}`,
+			want: []string{"/server"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := newInput(t, rc, "/example-validate:connect", `{"example-validate:input":`+tt.input+`}`)
+			violations := Validate(input)
+			if len(violations) != len(tt.want) {
+				t.Fatalf("Validate() = %d violations, want %d", len(violations), len(tt.want))
+			}
+			for i, v := range violations {
+				if v.Tag != ETagMissingElement || !strings.HasSuffix(v.Path, tt.want[i]) {
+					t.Errorf("Validate() = %s %s, want missing-element %s", v.Tag, v.Path, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-validate.yang"}, *dir, *excludes)
+	tests := []struct {
+		name   string
+		input  string
+		tag    ErrorTag
+		path   string // the path suffix of the violation: no violation if empty
+		apptag string
+		msg    string
+	}{
+		{name: "valid", input: `{"name":"abc","count":5,"min":1,"max":2}`},
+		{name: "range", input: `{"name":"abc","count":11}`, tag: ETagInvalidValue, path: "/count"},
+		{name: "length", input: `{"name":"abcdefghij"}`, tag: ETagInvalidValue, path: "/name"},
+		{name: "pattern", input: `{"name":"ABC"}`, tag: ETagInvalidValue, path: "/name"},
+		{name: "mandatory", input: `{"count":1}`, tag: ETagMissingElement, path: "/name"},
+		{name: "must", input: `{"name":"abc","min":5,"max":2}`, tag: ETagOperationFailed, path: "/max",
+			apptag: "max-below-min", msg: "max must not be less than min"},
+		{name: "current()", input: `{"name":"abc","count":5,"limit":5}`},
+		{name: "current() violated", input: `{"name":"abc","count":5,"limit":6}`, tag: ETagOperationFailed,
+			path: "/limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := newInput(t, rc, "/example-validate:configure", `{"example-validate:input":`+tt.input+`}`)
+			violations := Validate(input)
+			if tt.path == "" {
+				if len(violations) != 0 {
+					t.Fatalf("Validate() = %d violations, want 0", len(violations))
+				}
+				return
+			}
+			if len(violations) != 1 {
+				t.Fatalf("Validate() = %d violations, want 1", len(violations))
+			}
+			v := violations[0]
+			if v.Tag != tt.tag || !strings.HasSuffix(v.Path, tt.path) {
+				t.Errorf("Validate() = %s %s, want %s %s", v.Tag, v.Path, tt.tag, tt.path)
+			}
+			if v.AppTag != tt.apptag || (tt.msg != "" && v.Msg != tt.msg) {
+				t.Errorf("Validate() = %q %q, want %q %q", v.AppTag, v.Msg, tt.apptag, tt.msg)
+			}
+		})
+	}
+}
+
+func Test_InvokeViolation(t *testing.T) {
+	rc := loadSchema([]string{"testdata/example-validate.yang"}, *dir, *excludes)
+	root, err := yangtree.New(rc.schemaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.DataRoot = root
+	err = rc.RegisterRPC("/example-validate:configure", func(req *RPCRequest) (yangtree.DataNode, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+	if err := InstallRouteRESTCONF(app, rc); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/restconf/operations/example-validate:configure",
+		strings.NewReader(`{"example-validate:input":{"name":"abc","min":5,"max":2}}`))
+	req.Header.Set(fiber.HeaderContentType, "application/yang-data+json")
+	req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+	var body map[string]map[string][]map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	var errs []map[string]interface{}
+	for _, errors := range body {
+		errs = errors["error"]
+	}
+	if len(errs) != 1 {
+		t.Fatalf("%d errors in the response, want 1", len(errs))
+	}
+	want := map[string]string{
+		"error-tag":     "operation-failed",
+		"error-app-tag": "max-below-min",
+		"error-message": "max must not be less than min",
+	}
+	for k, v := range want {
+		if errs[0][k] != v {
+			t.Errorf("%s = %v, want %s", k, errs[0][k], v)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+// RFC8072 YANG Patch Media Type
+
+// YANGPatchEdit is an edit entry of the YANG Patch.
+type YANGPatchEdit struct {
+	EditID    string
+	Operation string
+	Target    string
+	Point     string
+	Where     string
+	Value     []byte // encoded value of the edit
+}
+
+// YANGPatch is the YANG Patch request decoded from the message-body.
+type YANGPatch struct {
+	PatchID string
+	Comment string
+	Edits   []*YANGPatchEdit
+	// unmarshal() decodes the value of the edit into the node.
+	unmarshal func(node yangtree.DataNode, value []byte) error
+}
+
+type yangPatchJSON struct {
+	YANGPatch *struct {
+		PatchID string `json:"patch-id"`
+		Comment string `json:"comment"`
+		Edit    []struct {
+			EditID    string          `json:"edit-id"`
+			Operation string          `json:"operation"`
+			Target    string          `json:"target"`
+			Point     string          `json:"point"`
+			Where     string          `json:"where"`
+			Value     json.RawMessage `json:"value"`
+		} `json:"edit"`
+	} `json:"ietf-yang-patch:yang-patch"`
+}
+
+type yangPatchXML struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:yang:ietf-yang-patch yang-patch"`
+	PatchID string   `xml:"patch-id"`
+	Comment string   `xml:"comment"`
+	Edit    []struct {
+		EditID    string `xml:"edit-id"`
+		Operation string `xml:"operation"`
+		Target    string `xml:"target"`
+		Point     string `xml:"point"`
+		Where     string `xml:"where"`
+		Value     struct {
+			Inner []byte `xml:",innerxml"`
+		} `xml:"value"`
+	} `xml:"edit"`
+}
+
+// isYANGPatch() returns true if the request is the YANG Patch request.
+func isYANGPatch(c *fiber.Ctx) bool {
+	return strings.HasPrefix(string(c.Request().Header.ContentType()), "application/yang-patch")
+}
+
+// ParseYANGPatch() decodes the YANG Patch in the message-body.
+func ParseYANGPatch(contentType string, body []byte) (*YANGPatch, error) {
+	patch := &YANGPatch{}
+	switch contentType {
+	case "application/yang-patch+json":
+		var jpatch yangPatchJSON
+		if err := json.Unmarshal(body, &jpatch); err != nil {
+			return nil, err
+		}
+		if jpatch.YANGPatch == nil {
+			return nil, fmt.Errorf("ietf-yang-patch:yang-patch not found")
+		}
+		patch.PatchID = jpatch.YANGPatch.PatchID
+		patch.Comment = jpatch.YANGPatch.Comment
+		for _, e := range jpatch.YANGPatch.Edit {
+			patch.Edits = append(patch.Edits, &YANGPatchEdit{
+				EditID: e.EditID, Operation: e.Operation, Target: e.Target,
+				Point: e.Point, Where: e.Where, Value: e.Value,
+			})
+		}
+		patch.unmarshal = func(node yangtree.DataNode, value []byte) error {
+			return yangtree.UnmarshalJSON(node, value)
+		}
+	case "application/yang-patch+xml":
+		var xpatch yangPatchXML
+		if err := xml.Unmarshal(body, &xpatch); err != nil {
+			return nil, err
+		}
+		patch.PatchID = xpatch.PatchID
+		patch.Comment = xpatch.Comment
+		for _, e := range xpatch.Edit {
+			patch.Edits = append(patch.Edits, &YANGPatchEdit{
+				EditID: e.EditID, Operation: e.Operation, Target: e.Target,
+				Point: e.Point, Where: e.Where, Value: e.Value.Inner,
+			})
+		}
+		patch.unmarshal = func(node yangtree.DataNode, value []byte) error {
+			return yangtree.UnmarshalXML(node, value)
+		}
+	default:
+		return nil, fmt.Errorf("not supported YANG Patch media type %q", contentType)
+	}
+	if patch.PatchID == "" {
+		return nil, fmt.Errorf("patch-id not present")
+	}
+	for _, e := range patch.Edits {
+		if e.EditID == "" || e.Operation == "" || e.Target == "" {
+			return nil, fmt.Errorf("edit-id, operation and target must be present in the edit")
+		}
+		if e.Where == "" {
+			e.Where = "last"
+		}
+	}
+	return patch, nil
+}
+
+// isOrderedByUser() returns true if the list or leaf-list is ordered by user.
+func isOrderedByUser(schema *yangtree.SchemaNode) bool {
+	return schema.ListAttr != nil && schema.ListAttr.OrderedBy != nil &&
+		schema.ListAttr.OrderedBy.Name == "user"
+}
+
+// insertOption() returns the yangtree insert option for the where and point.
+func insertOption(where string, point yangtree.DataNode) yangtree.InsertOption {
+	switch where {
+	case "first":
+		return yangtree.InsertToFirst{}
+	case "before":
+		return yangtree.InsertToBefore{Key: point.ID()}
+	case "after":
+		return yangtree.InsertToAfter{Key: point.ID()}
+	default:
+		return yangtree.InsertToLast{}
+	}
+}
+
+// resolvePoint() returns the data node identified by the point that is
+// used as the insertion point of the ordered-by user list. The point must be
+// an entry of the list in the parent of the ppath.
+func (rc *RESTCtrl) resolvePoint(root yangtree.DataNode, schema *yangtree.SchemaNode,
+	ppath, epath, where, point string) (yangtree.DataNode, error) {
+	switch where {
+	case "first", "last":
+		return nil, nil
+	case "before", "after":
+	default:
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, fmt.Sprintf("invalid insert %q", where))
+	}
+	if point == "" {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagMissingElement, epath, "point must be present for before or after")
+	}
+	pschema, ppoint, err := RPath2XPath(rc.schemaData, &point)
+	if err != nil {
+		return nil, rc.pathError("/restconf/data", err)
+	}
+	if pschema != schema {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "point must identify an entry of the same list")
+	}
+	if strings.Join(revisionPath(parentPath(ppoint)), "/") != strings.Join(revisionPath(ppath), "/") {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "point must identify an entry in the same parent")
+	}
+	found, err := yangtree.Find(root, ppoint)
+	if err != nil || len(found) != 1 {
+		return nil, NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "unable to find the point resource")
+	}
+	return found[0], nil
+}
+
+// applyEdit() applies an edit of the YANG Patch to the root.
+func (rc *RESTCtrl) applyEdit(root yangtree.DataNode, patch *YANGPatch, uri string, edit *YANGPatchEdit) error {
+	target := strings.TrimSuffix(uri, "/") + edit.Target
+	if edit.Target == "/" {
+		target = uri
+	}
+	epath := "/restconf/data" + target
+	schema, xpath, err := RPath2XPath(rc.schemaData, &target)
+	if err != nil {
+		return rc.pathError("/restconf/data", err)
+	}
+	if schema == rc.schemaData {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "the target must not be the datastore resource")
+	}
+	if schema.IsState {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, "unable to edit non-configuration data")
+	}
+	found, err := yangtree.Find(root, xpath)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, epath, err)
+	}
+
+	switch edit.Operation {
+	case "delete", "remove":
+		if len(found) == 0 {
+			if edit.Operation == "remove" {
+				return nil
+			}
+			return NewError(rc, fiber.StatusConflict, ETypeApplication,
+				ETagDataMissing, epath, "unable to find the target resource")
+		}
+		for i := range found {
+			if err := found[i].Remove(); err != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, epath, err)
+			}
+		}
+		return nil
+	case "move":
+		if len(found) != 1 {
+			return NewError(rc, fiber.StatusConflict, ETypeApplication,
+				ETagDataMissing, epath, "unable to find the target resource")
+		}
+		if !isOrderedByUser(schema) {
+			return NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+				ETagInvalidValue, epath, "the target is not an ordered-by user list")
+		}
+		point, err := rc.resolvePoint(root, schema, parentPath(xpath), epath, edit.Where,
+			strings.TrimSuffix(uri, "/")+edit.Point)
+		if err != nil {
+			return err
+		}
+		node := found[0]
+		parent := node.Parent()
+		if err := node.Remove(); err != nil {
+			return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+				ETagOperationFailed, epath, err)
+		}
+		if _, err := parent.Insert(node, &yangtree.EditOption{
+			InsertOption: insertOption(edit.Where, point)}); err != nil {
+			return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+				ETagInvalidValue, epath, err)
+		}
+		return nil
+	case "create", "insert", "merge", "replace":
+	default:
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+			ETagInvalidValue, epath, fmt.Sprintf("invalid operation %q", edit.Operation))
+	}
+
+	node, err := rc.decodeTarget(epath, schema, xpath, func(parent yangtree.DataNode) error {
+		if err := patch.unmarshal(parent, edit.Value); err != nil {
+			return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+				ETagMarlformedMessage, epath, fmt.Sprintf("parsing error: %v", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(found) > 1 {
+		return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+			epath, "the target identifies multiple data resources")
+	}
+	var editopt *yangtree.EditOption
+	switch edit.Operation {
+	case "create", "insert":
+		if len(found) > 0 {
+			return NewError(rc, fiber.StatusConflict, ETypeApplication,
+				ETagDataExists, epath, "the target resource already exists")
+		}
+		if edit.Operation == "insert" {
+			if !isOrderedByUser(schema) {
+				return NewError(rc, fiber.StatusBadRequest, ETypeProtocol,
+					ETagInvalidValue, epath, "the target is not an ordered-by user list")
+			}
+			point, err := rc.resolvePoint(root, schema, parentPath(xpath), epath, edit.Where,
+				strings.TrimSuffix(uri, "/")+edit.Point)
+			if err != nil {
+				return err
+			}
+			editopt = &yangtree.EditOption{InsertOption: insertOption(edit.Where, point)}
+		}
+	case "merge":
+		if len(found) > 0 {
+			if err := found[0].Merge(node); err != nil {
+				return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+					ETagInvalidValue, epath, err)
+			}
+			return nil
+		}
+	case "replace":
+		if len(found) > 0 {
+			if schema.IsKey && found[0].ValueString() != node.ValueString() {
+				return NewError(rc, fiber.StatusBadRequest, ETypeProtocol, ETagInvalidValue,
+					epath, "unable to change the key leaf of the list entry")
+			}
+			if err := found[0].Remove(); err != nil {
+				return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, epath, err)
+			}
+		}
+	}
+	parent, _, err := getOrNewParent(root, xpath)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, epath, err)
+	}
+	if _, err := parent.Insert(node, editopt); err != nil {
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagInvalidValue, epath, err)
+	}
+	return nil
+}
+
+// newPatchStatus() returns the yang-patch-status data node. If re is nil,
+// the ok status of the patch and each edit is reported. Otherwise, the errors
+// of the failed edit are reported in the edit-status of the edit.
+func (rc *RESTCtrl) newPatchStatus(patch *YANGPatch, editID string, re *RespError) (yangtree.DataNode, error) {
+	status, err := yangtree.New(rc.schemaPatchStatus)
+	if err != nil {
+		return nil, err
+	}
+	if err := yangtree.SetValue(status, "patch-id", nil, patch.PatchID); err != nil {
+		return nil, err
+	}
+	if re == nil {
+		if err := yangtree.SetValue(status, "ok", nil); err != nil {
+			return nil, err
+		}
+		for _, e := range patch.Edits {
+			id, err := xpathValue(e.EditID)
+			if err != nil {
+				return nil, err
+			}
+			epath := fmt.Sprintf("edit-status/edit[edit-id=%s]/ok", id)
+			if err := yangtree.SetValue(status, epath, nil); err != nil {
+				return nil, err
+			}
+		}
+		return status, nil
+	}
+	id, err := xpathValue(editID)
+	if err != nil {
+		return nil, err
+	}
+	epath := fmt.Sprintf("edit-status/edit[edit-id=%s]", id)
+	if err := yangtree.SetValue(status, epath+"/edit-id", nil, editID); err != nil {
+		return nil, err
+	}
+	found, err := yangtree.Find(status, epath)
+	if err != nil || len(found) != 1 {
+		return nil, fmt.Errorf("unable to find %s in yang-patch-status", epath)
+	}
+	enode, err := re.Convert(found[0].Schema().GetSchema("errors"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := found[0].Insert(enode, nil); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// YANGPatch() applies all edits of the YANG Patch in the message-body to
+// the target data resource as a transaction. (RFC8072)
+func (rc *RESTCtrl) YANGPatch(c *fiber.Ctx, schema *yangtree.SchemaNode, uri, xpath string) error {
+	contentType := string(c.Request().Header.ContentType())
+	patch, err := ParseYANGPatch(contentType, c.Body())
+	if err != nil {
+		return NewError(rc, fiber.StatusBadRequest, ETypeApplication,
+			ETagMarlformedMessage, c.Path(), fmt.Sprintf("parsing error: %v", err))
+	}
+	// The edits are applied to the copy of the datastore and then
+	// the copy becomes the datastore if all edits are applied.
+	root := yangtree.Clone(rc.DataRoot)
+	for _, edit := range patch.Edits {
+		if err := rc.applyEdit(root, patch, uri, edit); err != nil {
+			re, ok := err.(*RespError)
+			if !ok {
+				re = NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+					ETagOperationFailed, c.Path(), err)
+			}
+			status, err := rc.newPatchStatus(patch, edit.EditID, re)
+			if err != nil {
+				return re
+			}
+			return rc.Response(c, &RespData{Nodes: []yangtree.DataNode{status}, Status: re.Code})
+		}
+	}
+	status, err := rc.newPatchStatus(patch, "", nil)
+	if err != nil {
+		return NewError(rc, fiber.StatusInternalServerError, ETypeApplication,
+			ETagOperationFailed, c.Path(), err)
+	}
+	rc.DataRoot = root
+	rc.revisions.Touch(xpath)
+	return rc.Response(c, &RespData{Nodes: []yangtree.DataNode{status}})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/neoul/yangtree"
+)
+
+func Test_ParseYANGPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantEdits   int
+		wantErr     bool
+	}{
+		{
+			name:        "json",
+			contentType: "application/yang-patch+json",
+			body: `{"ietf-yang-patch:yang-patch": {"patch-id": "add-songs-patch",
+				"edit": [{"edit-id": "edit1", "operation": "create", "target": "/song=5",
+				"value": {"example-jukebox:song": [{"name": "5", "location": "/media/5.mp3"}]}},
+				{"edit-id": "edit2", "operation": "delete", "target": "/song=1"}]}}`,
+			wantEdits: 2,
+		},
+		{
+			name:        "xml",
+			contentType: "application/yang-patch+xml",
+			body: `<yang-patch xmlns="urn:ietf:params:xml:ns:yang:ietf-yang-patch">
+				<patch-id>add-songs-patch</patch-id>
+				<edit><edit-id>edit1</edit-id><operation>insert</operation><target>/song=5</target>
+				<where>after</where><point>/song=4</point>
+				<value><song xmlns="http://example.com/ns/example-jukebox"><name>5</name></song></value>
+				</edit></yang-patch>`,
+			wantEdits: 1,
+		},
+		{
+			name:        "no patch-id",
+			contentType: "application/yang-patch+json",
+			body:        `{"ietf-yang-patch:yang-patch": {"edit": []}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "no target",
+			contentType: "application/yang-patch+json",
+			body:        `{"ietf-yang-patch:yang-patch": {"patch-id": "p", "edit": [{"edit-id": "e", "operation": "delete"}]}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "unknown media type",
+			contentType: "application/yang-patch+yaml",
+			body:        `patch-id: p`,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParseYANGPatch(tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseYANGPatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if len(patch.Edits) != tt.wantEdits {
+				t.Errorf("ParseYANGPatch() got %d edits, want %d", len(patch.Edits), tt.wantEdits)
+			}
+			for _, e := range patch.Edits {
+				if e.Operation != "delete" && len(e.Value) == 0 {
+					t.Errorf("ParseYANGPatch() value of %s not decoded", e.EditID)
+				}
+			}
+		})
+	}
+}
+
+func Test_YANGPatch(t *testing.T) {
+	type editStatus struct {
+		EditID string          `json:"edit-id"`
+		OK     json.RawMessage `json:"ok"`
+		Errors json.RawMessage `json:"errors"`
+	}
+	type patchStatus struct {
+		Status struct {
+			PatchID    string          `json:"patch-id"`
+			OK         json.RawMessage `json:"ok"`
+			EditStatus struct {
+				Edit []editStatus `json:"edit"`
+			} `json:"edit-status"`
+		} `json:"ietf-yang-patch:yang-patch-status"`
+	}
+	tests := []struct {
+		name   string
+		edits  string
+		status int
+		ok     []string // the edit-id of the edits reported ok
+		failed string   // the edit-id of the edit reported with the errors
+	}{
+		{
+			name: "applied",
+			edits: `{"edit-id": "edit1", "operation": "create", "target": "/artist=A",
+				"value": {"example-jukebox:artist": [{"name": "A"}]}},
+				{"edit-id": "edit2", "operation": "merge", "target": "/artist=Other",
+				"value": {"example-jukebox:artist": [{"name": "Other", "album": [{"name": "X"}]}]}}`,
+			status: fiber.StatusOK,
+			ok:     []string{"edit1", "edit2"},
+		},
+		{
+			name: "second edit failed",
+			edits: `{"edit-id": "edit1", "operation": "create", "target": "/artist=A",
+				"value": {"example-jukebox:artist": [{"name": "A"}]}},
+				{"edit-id": "edit2", "operation": "create", "target": "/artist=Other",
+				"value": {"example-jukebox:artist": [{"name": "Other"}]}}`,
+			status: fiber.StatusConflict,
+			failed: "edit2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := loadSchema([]string{"modules/example/example-jukebox.yang"}, *dir, *excludes)
+			root, err := yangtree.New(rc.schemaData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := yangtree.UnmarshalJSON(root,
+				[]byte(`{"example-jukebox:jukebox":{"library":{"artist":[{"name":"Other"}]}}}`)); err != nil {
+				t.Fatal(err)
+			}
+			rc.DataRoot = root
+			before, err := yangtree.MarshalJSON(rc.DataRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := fiber.New(fiber.Config{ErrorHandler: errhandler})
+			if err := InstallRouteRESTCONF(app, rc); err != nil {
+				t.Fatal(err)
+			}
+			body := `{"ietf-yang-patch:yang-patch": {"patch-id": "p1", "edit": [` + tt.edits + `]}}`
+			req := httptest.NewRequest("PATCH", "/restconf/data/example-jukebox:jukebox/library",
+				strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, "application/yang-patch+json")
+			req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			var got patchStatus
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.PatchID != "p1" {
+				t.Errorf("patch-id = %q, want %q", got.Status.PatchID, "p1")
+			}
+			if (got.Status.OK != nil) != (tt.failed == "") {
+				t.Errorf("ok of the patch = %s", got.Status.OK)
+			}
+			var ok []string
+			var failed string
+			for _, e := range got.Status.EditStatus.Edit {
+				if e.OK != nil {
+					ok = append(ok, e.EditID)
+				}
+				if e.Errors != nil {
+					failed = e.EditID
+				}
+			}
+			if !reflect.DeepEqual(ok, tt.ok) {
+				t.Errorf("edits reported ok = %v, want %v", ok, tt.ok)
+			}
+			if failed != tt.failed {
+				t.Errorf("edit reported failed = %q, want %q", failed, tt.failed)
+			}
+			after, err := yangtree.MarshalJSON(rc.DataRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := string(before) != string(after); changed != (tt.failed == "") {
+				t.Errorf("datastore changed = %v, want %v: %s", changed, tt.failed == "", after)
+			}
+		})
+	}
+}
+
+func Test_YANGPatchInsert(t *testing.T) {
+	const data = `{"example-jukebox:jukebox":{"library":{"artist":[{"name":"A"}]},
+		"playlist":[{"name":"p","song":[
+			{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":2,"id":"/example-jukebox:jukebox/library/artist[name='A']"},
+			{"index":3,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]},
+		{"name":"q","song":[
+			{"index":1,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]}]}}`
+	const song = `{"example-jukebox:song":[{"index":4,"id":"/example-jukebox:jukebox/library/artist[name='A']"}]}`
+	tests := []struct {
+		name   string
+		edit   string
+		status int
+		order  []string // the indexes of the songs of the playlist p after the patch
+	}{
+		{name: "insert first", edit: `"operation":"insert","target":"/playlist=p/song=4","where":"first",
+			"value":` + song, status: fiber.StatusOK, order: []string{"4", "1", "2", "3"}},
+		{name: "insert before", edit: `"operation":"insert","target":"/playlist=p/song=4","where":"before",
+			"point":"/playlist=p/song=2","value":` + song, status: fiber.StatusOK, order: []string{"1", "4", "2", "3"}},
+		{name: "move last", edit: `"operation":"move","target":"/playlist=p/song=1","where":"last"`,
+			status: fiber.StatusOK, order: []string{"2", "3", "1"}},
+		{name: "move after", edit: `"operation":"move","target":"/playlist=p/song=1","where":"after",
+			"point":"/playlist=p/song=2"`, status: fiber.StatusOK, order: []string{"2", "1", "3"}},
+		{name: "point in another parent", edit: `"operation":"move","target":"/playlist=p/song=1","where":"before",
+			"point":"/playlist=q/song=1"`, status: fiber.StatusBadRequest, order: []string{"1", "2", "3"}},
+		{name: "not ordered-by user", edit: `"operation":"insert","target":"/library/artist=B","where":"first",
+			"value":{"example-jukebox:artist":[{"name":"B"}]}`, status: fiber.StatusBadRequest,
+			order: []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, app := newJukebox(t, data)
+			body := `{"ietf-yang-patch:yang-patch":{"patch-id":"p1","edit":[{"edit-id":"e1",` + tt.edit + `}]}}`
+			req := httptest.NewRequest("PATCH", "/restconf/data/example-jukebox:jukebox", strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, "application/yang-patch+json")
+			req.Header.Set(fiber.HeaderAccept, "application/yang-data+json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("PATCH status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if order := songOrder(t, rc, "p"); !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("songs = %v, want %v", order, tt.order)
+			}
+		})
+	}
+}